@@ -0,0 +1,164 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+// Change of a finding compared with the live state of the application
+const (
+	changeNew      = "new"
+	changeExisting = "existing"
+	changeFixed    = "fixed"
+)
+
+// Values of --fail-on
+const (
+	failOnAll = "all"
+	failOnNew = "new"
+)
+
+// validateFailOn checks the --fail-on flag before any guard runs
+func validateFailOn(failOn string) error {
+	switch failOn {
+	case "", failOnAll, failOnNew:
+		return nil
+	}
+	return fmt.Errorf("unknown --fail-on '%s', should be one of: %s|%s", failOn, failOnAll, failOnNew)
+}
+
+// evaluate runs the guards on the application. Only --fail-on new and the structured reports use the change
+// of a finding, without them the live state isn't guarded.
+func (o *guardRunOptions) evaluate(app *GuardContext, guardsToRun []Guard) *Report {
+	if o.failOn == failOnNew || (o.output != "" && o.output != outputText) {
+		return evaluateChanges(app, guardsToRun)
+	}
+	return evaluateGuards(app, guardsToRun)
+}
+
+// evaluateChanges runs the guards against the desired state of the application and against its live state,
+// then labels every finding as introduced by this change, pre-existing, or fixed by this change
+func evaluateChanges(app *GuardContext, guardsToRun []Guard) *Report {
+	report := evaluateGuards(app, guardsToRun)
+
+	after := allFindings(report)
+	existing := make([]bool, len(after))
+	if live := liveStateContext(app); live != nil {
+		log.Debugf("Guarding the live state of application %s", app.AppName)
+		liveReport := quietly(func() *Report { return evaluateGuards(live, guardsToRun) })
+		before := allFindings(liveReport)
+		existing = matchFindings(after, before)
+		for i, kept := range matchFindings(before, after)[:len(liveReport.Findings)] {
+			if !kept {
+				finding := liveReport.Findings[i]
+				finding.Change = changeFixed
+				report.Fixed = append(report.Fixed, finding)
+			}
+		}
+	}
+
+	for i := range report.Findings {
+		report.Findings[i].Change = change(existing[i])
+	}
+	for i := range report.Suppressed {
+		report.Suppressed[i].Change = change(existing[len(report.Findings)+i])
+	}
+	return report
+}
+
+// quietly runs the guards on the live state without repeating their findings and dry run patches in the log,
+// unless debug logging is on
+func quietly(evaluate func() *Report) *Report {
+	if level := log.GetLevel(); level < log.DebugLevel {
+		log.SetLevel(log.ErrorLevel)
+		defer log.SetLevel(level)
+	}
+	return evaluate()
+}
+
+// allFindings returns the reported findings followed by the suppressed ones
+func allFindings(report *Report) []Finding {
+	findings := make([]Finding, 0, len(report.Findings)+len(report.Suppressed))
+	findings = append(findings, report.Findings...)
+	return append(findings, report.Suppressed...)
+}
+
+func change(existing bool) string {
+	if existing {
+		return changeExisting
+	}
+	return changeNew
+}
+
+// liveStateContext returns the application as it is deployed: the desired state of every resource is the configuration
+// it was applied with. Nothing is patched. It returns nil if nothing is deployed yet.
+func liveStateContext(app *GuardContext) *GuardContext {
+	resources := make([]*argoappv1.ResourceDiff, 0, len(app.Resources))
+	for _, resource := range app.Resources {
+		live, err := resource.LiveObject()
+		if err != nil || live == nil {
+			continue
+		}
+		target, err := json.Marshal(desiredState(live))
+		if err != nil {
+			continue
+		}
+		liveResource := resource.DeepCopy()
+		liveResource.TargetState = string(target)
+		resources = append(resources, liveResource)
+	}
+	if len(resources) == 0 {
+		return nil
+	}
+
+	live := *app
+	live.Resources = resources
+	live.Patcher = nil
+	live.DryRun = true
+	return &live
+}
+
+// failingFindings returns the findings which fail the guards with --fail-on
+func failingFindings(findings []Finding, failOn string) []Finding {
+	if failOn != failOnNew {
+		return findings
+	}
+	result := make([]Finding, 0)
+	for _, finding := range findings {
+		if failing(finding, failOn) {
+			result = append(result, finding)
+		}
+	}
+	return result
+}
+
+// failing tells whether an error finding fails the guards with --fail-on, the reports don't flag the others as failures
+func failing(finding Finding, failOn string) bool {
+	return failOn != failOnNew || finding.Change == changeNew || finding.Change == ""
+}
+
+// logFixed writes the findings fixed by this change to the log
+func logFixed(findings []Finding) {
+	for _, finding := range findings {
+		log.Infof("Fixed by this change: %s", finding.String())
+	}
+}
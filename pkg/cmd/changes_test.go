@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func changeContext(t *testing.T, targets []string, lives []string) *GuardContext {
+	targetObjs, err := decodeManifests(strings.NewReader(strings.Join(targets, "\n---\n")), "test")
+	assert.Nil(t, err)
+	liveObjs, err := decodeManifests(strings.NewReader(strings.Join(lives, "\n---\n")), "test")
+	assert.Nil(t, err)
+	diffs, err := manifestResourceDiffs(targetObjs, liveObjs)
+	assert.Nil(t, err)
+	return &GuardContext{AppName: "web-prd", Resources: diffs, DryRun: true, Config: &GuardConfig{}, Production: true}
+}
+
+func TestEvaluateChanges(t *testing.T) {
+	app := changeContext(t,
+		[]string{pdbDeployment, pdbManifest("web-pdb", "web", "minAvailable: 1"), serviceManifest("app: api", "http")},
+		[]string{pdbDeployment, pdbManifest("web-pdb", "web", "minAvailable: 2")})
+	report := evaluateChanges(app, []Guard{LookupGuard("pdb"), LookupGuard("service")})
+
+	assert.Equal(t, []string{"service-selects-nothing"}, findingRules(report.Findings))
+	assert.Equal(t, changeNew, report.Findings[0].Change)
+	assert.Contains(t, report.Findings[0].String(), "[service/service-selects-nothing] (new)")
+	assert.Equal(t, []string{"pdb-blocks-eviction"}, findingRules(report.Fixed))
+	assert.Equal(t, changeFixed, report.Fixed[0].Change)
+}
+
+func TestEvaluateGuardsLiveStateOnlyWhenNeeded(t *testing.T) {
+	app := changeContext(t, []string{pdbDeployment}, []string{pdbDeployment})
+	guards := []Guard{LookupGuard("pdb")}
+
+	report := (&guardRunOptions{failOn: failOnAll, output: outputText}).evaluate(app, guards)
+	assert.Equal(t, []string{"pdb-missing"}, findingRules(report.Findings))
+	assert.Equal(t, "", report.Findings[0].Change)
+
+	for _, opts := range []guardRunOptions{{failOn: failOnNew}, {failOn: failOnAll, output: outputJSON}} {
+		report = opts.evaluate(app, guards)
+		assert.Equal(t, changeExisting, report.Findings[0].Change)
+	}
+}
+
+func TestFailOnNew(t *testing.T) {
+	app := changeContext(t, []string{pdbDeployment}, []string{pdbDeployment})
+	report := evaluateChanges(app, []Guard{LookupGuard("pdb")})
+	assert.Equal(t, []string{"pdb-missing"}, findingRules(report.Findings))
+	assert.Equal(t, changeExisting, report.Findings[0].Change)
+	assert.Equal(t, exitCodeViolations, finishReport(report, guardRunOptions{failOn: failOnAll}))
+	assert.Equal(t, exitCodeOK, finishReport(report, guardRunOptions{failOn: failOnNew}))
+
+	var b bytes.Buffer
+	assert.Nil(t, writeReport(&b, outputJUnit, report))
+	suites := junitTestSuites{}
+	assert.Nil(t, xml.Unmarshal(b.Bytes(), &suites))
+	assert.EqualValues(t, 0, suites.Failures)
+	assert.Nil(t, suites.Suites[0].TestCases[0].Failure)
+	assert.Contains(t, suites.Suites[0].TestCases[0].SystemOut, "(existing)")
+
+	b.Reset()
+	assert.Nil(t, writeReport(&b, outputSARIF, report))
+	assert.Contains(t, b.String(), `"level": "warning"`)
+	assert.NotContains(t, b.String(), `"level": "error"`)
+
+	report.failOn = failOnAll
+	b.Reset()
+	assert.Nil(t, writeReport(&b, outputJUnit, report))
+	assert.Contains(t, b.String(), "<failure")
+
+	app = changeContext(t, []string{pdbDeployment}, nil)
+	report = evaluateChanges(app, []Guard{LookupGuard("pdb")})
+	assert.Equal(t, changeNew, report.Findings[0].Change)
+	assert.Equal(t, exitCodeViolations, finishReport(report, guardRunOptions{failOn: failOnNew}))
+
+	combined := newCombinedReport()
+	combined.failOn = failOnNew
+	combined.addApp(evaluateChanges(changeContext(t, []string{pdbDeployment}, []string{pdbDeployment}), []Guard{LookupGuard("pdb")}))
+	assert.Equal(t, appStatusPassed, combined.Apps[0].Status)
+
+	assert.Nil(t, validateFailOn(failOnNew))
+	assert.NotNil(t, validateFailOn("old"))
+}
+
+func TestEvaluateChangesSameRuleOnResource(t *testing.T) {
+	live := probeDeployment(`
+        readinessProbe:
+          tcpSocket:
+            port: https
+`)
+	target := live + `
+      - name: sidecar
+        image: sidecar:latest
+        readinessProbe:
+          tcpSocket:
+            port: metrics
+`
+	report := evaluateChanges(changeContext(t, []string{target}, []string{live}), []Guard{LookupGuard("probe")})
+	assert.Equal(t, []string{"probe-port-missing", "probe-port-missing"}, findingRules(report.Findings))
+	assert.Equal(t, changeExisting, report.Findings[0].Change)
+	assert.Equal(t, changeNew, report.Findings[1].Change)
+	assert.Contains(t, report.Findings[1].Message, "container sidecar")
+	assert.Equal(t, exitCodeViolations, finishReport(report, guardRunOptions{failOn: failOnNew}))
+
+	report = evaluateChanges(changeContext(t, []string{live}, []string{target}), []Guard{LookupGuard("probe")})
+	assert.Equal(t, []string{"probe-port-missing"}, findingRules(report.Fixed))
+	assert.Contains(t, report.Fixed[0].Message, "container sidecar")
+}
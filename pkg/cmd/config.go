@@ -0,0 +1,198 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultGuardConfigPath is used when --guard-config is not given and the file exists
+const defaultGuardConfigPath = "conf/guard.yaml"
+
+// GuardConfig tunes the guards without changing the code
+type GuardConfig struct {
+	Guards     map[string]GuardSettings `json:"guards,omitempty"`
+	Exceptions []GuardException         `json:"exceptions,omitempty"`
+}
+
+// GuardSettings are the settings of a single guard
+type GuardSettings struct {
+	// Enabled is true by default, a disabled guard is skipped by `all`
+	Enabled *bool `json:"enabled,omitempty"`
+	// Rules overrides the rules of the guard by rule id
+	Rules map[string]RuleSettings `json:"rules,omitempty"`
+	// Settings are guard specific, e.g. the annotation which triggers the ingress guard
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// RuleSettings overrides the findings of a rule
+type RuleSettings struct {
+	// Enabled is true by default, findings of a disabled rule are dropped
+	Enabled *bool `json:"enabled,omitempty"`
+	// Severity replaces the severity of the findings
+	Severity Severity `json:"severity,omitempty"`
+	// Documentation replaces the remediation link of the findings
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// GuardException drops the findings it matches, empty fields match everything.
+// App, Namespace and Name are shell patterns, e.g. "payments-*".
+type GuardException struct {
+	Guard     string `json:"guard,omitempty"`
+	Rule      string `json:"rule,omitempty"`
+	App       string `json:"app,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+// loadGuardConfig reads the config from the given path, or from conf/guard.yaml if it exists
+func loadGuardConfig(configPath string) (*GuardConfig, error) {
+	if configPath == "" {
+		if _, err := os.Stat(defaultGuardConfigPath); err != nil {
+			return &GuardConfig{}, nil
+		}
+		configPath = defaultGuardConfigPath
+	}
+
+	bytes, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	config := &GuardConfig{}
+	if err := yaml.Unmarshal(bytes, config); err != nil {
+		return nil, fmt.Errorf("not able to parse guard config %s: %v", configPath, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid guard config %s: %v", configPath, err)
+	}
+	log.Infof("Using guard config %s", configPath)
+	return config, nil
+}
+
+func (c *GuardConfig) validate() error {
+	for name, guard := range c.Guards {
+		if LookupGuard(name) == nil {
+			return fmt.Errorf("unknown guard '%s'", name)
+		}
+		for rule, settings := range guard.Rules {
+			switch settings.Severity {
+			case "", SeverityError, SeverityWarning, SeverityInfo:
+			default:
+				return fmt.Errorf("unknown severity '%s' of rule '%s'", settings.Severity, rule)
+			}
+		}
+	}
+	for i, exception := range c.Exceptions {
+		if exception.Reason == "" {
+			return fmt.Errorf("exception %d has no reason", i)
+		}
+		for _, pattern := range []string{exception.App, exception.Namespace, exception.Name} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("exception %d has a bad pattern '%s'", i, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// enabled tells whether `all` should run the guard
+func (c *GuardConfig) enabled(guard string) bool {
+	settings, ok := c.Guards[guard]
+	return !ok || settings.Enabled == nil || *settings.Enabled
+}
+
+// setting returns a guard specific setting, or the default value if it's not configured
+func (c *GuardConfig) setting(guard string, key string, defaultValue string) string {
+	if value, ok := c.Guards[guard].Settings[key]; ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// apply overrides the severity and documentation of the findings and drops the excepted ones
+func (c *GuardConfig) apply(appName string, findings []Finding) []Finding {
+	result := make([]Finding, 0, len(findings))
+	for _, finding := range findings {
+		if !c.override(&finding) {
+			log.Debugf("Rule '%s' is disabled, dropping %s", finding.Rule, finding.String())
+			continue
+		}
+		if exception := c.exception(appName, finding); exception != nil {
+			log.Infof("Finding is excepted (%s): %s", exception.Reason, finding.String())
+			continue
+		}
+		result = append(result, finding)
+	}
+	return result
+}
+
+// blocks tells whether the finding is still an error once the config is applied, without logging anything.
+// Guards changing the live objects check it before patching, the config is only applied to the report once every guard has run.
+func (c *GuardConfig) blocks(appName string, finding Finding) bool {
+	return c.override(&finding) && finding.Severity == SeverityError && c.exception(appName, finding) == nil
+}
+
+// override replaces the severity and documentation of the finding by the rule settings, it returns false if the rule is disabled
+func (c *GuardConfig) override(finding *Finding) bool {
+	settings := c.Guards[finding.Guard].Rules[finding.Rule]
+	if settings.Enabled != nil && !*settings.Enabled {
+		return false
+	}
+	if settings.Severity != "" {
+		finding.Severity = settings.Severity
+	}
+	if settings.Documentation != "" {
+		finding.Documentation = settings.Documentation
+	}
+	return true
+}
+
+// exception returns the first exception matching the finding, or nil
+func (c *GuardConfig) exception(appName string, finding Finding) *GuardException {
+	for i := range c.Exceptions {
+		exception := &c.Exceptions[i]
+		if matchValue(exception.Guard, finding.Guard) &&
+			matchValue(exception.Rule, finding.Rule) &&
+			matchValue(exception.Kind, finding.Kind) &&
+			matchPattern(exception.App, appName) &&
+			matchPattern(exception.Namespace, finding.Namespace) &&
+			matchPattern(exception.Name, finding.Name) {
+			return exception
+		}
+	}
+	return nil
+}
+
+func matchValue(expected string, value string) bool {
+	return expected == "" || expected == value
+}
+
+func matchPattern(pattern string, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const guardConfigYaml = `
+guards:
+  pdb:
+    enabled: false
+  ingress:
+    settings:
+      targetTypeAnnotation: example.com/target-type
+    rules:
+      ingress-readiness-gate-missing:
+        severity: warning
+        documentation: https://example.com/ingress
+      ingress-workload-invalid:
+        enabled: false
+exceptions:
+- guard: hpa
+  app: legacy-*
+  kind: Deployment
+  reason: Legacy apps manage their replicas
+`
+
+func writeGuardConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "cd-guard")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "guard.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLoadGuardConfig(t *testing.T) {
+	path, cleanup := writeGuardConfig(t, guardConfigYaml)
+	defer cleanup()
+
+	config, err := loadGuardConfig(path)
+	assert.Nil(t, err)
+	assert.True(t, config.enabled("hpa"))
+	assert.False(t, config.enabled("pdb"))
+	assert.Equal(t, "example.com/target-type", config.setting("ingress", "targetTypeAnnotation", defaultIngressTargetTypeAnnotation))
+	assert.Equal(t, defaultIngressTargetType, config.setting("ingress", "targetType", defaultIngressTargetType))
+
+	names := make([]string, 0)
+	for _, guard := range enabledGuards(config) {
+		names = append(names, guard.Name())
+	}
+	assert.NotContains(t, names, "pdb")
+	assert.Contains(t, names, "hpa")
+}
+
+func TestLoadDefaultGuardConfig(t *testing.T) {
+	config, err := loadGuardConfig("")
+	assert.Nil(t, err)
+	assert.Empty(t, config.Guards)
+	assert.True(t, config.enabled("pdb"))
+}
+
+func TestInvalidGuardConfig(t *testing.T) {
+	for _, content := range []string{
+		"guards:\n  unknown: {}\n",
+		"guards:\n  hpa:\n    rules:\n      hpa-replicas-set:\n        severity: fatal\n",
+		"exceptions:\n- guard: hpa\n",
+		"exceptions:\n- name: '['\n  reason: bad pattern\n",
+	} {
+		path, cleanup := writeGuardConfig(t, content)
+		_, err := loadGuardConfig(path)
+		assert.NotNil(t, err, content)
+		cleanup()
+	}
+}
+
+func TestApplyGuardConfig(t *testing.T) {
+	path, cleanup := writeGuardConfig(t, guardConfigYaml)
+	defer cleanup()
+	config, err := loadGuardConfig(path)
+	assert.Nil(t, err)
+
+	findings := []Finding{
+		{Guard: "ingress", Rule: "ingress-readiness-gate-missing", Severity: SeverityError, Kind: "Ingress", Name: "web", Documentation: defaultIngressDocumentation},
+		{Guard: "ingress", Rule: "ingress-workload-invalid", Severity: SeverityWarning, Kind: "Deployment", Name: "web"},
+		{Guard: "hpa", Rule: "hpa-replicas-set", Severity: SeverityError, Kind: "Deployment", Name: "web"},
+	}
+
+	result := config.apply("legacy-web-prd", findings)
+	assert.EqualValues(t, 1, len(result))
+	assert.EqualValues(t, SeverityWarning, result[0].Severity)
+	assert.EqualValues(t, "https://example.com/ingress", result[0].Documentation)
+
+	result = config.apply("web-prd", findings)
+	assert.EqualValues(t, 2, len(result))
+	assert.EqualValues(t, "hpa-replicas-set", result[1].Rule)
+	assert.EqualValues(t, SeverityError, result[1].Severity)
+}
+
+func TestIngressTargetTypeSetting(t *testing.T) {
+	path, cleanup := writeGuardConfig(t, guardConfigYaml)
+	defer cleanup()
+	config, err := loadGuardConfig(path)
+	assert.Nil(t, err)
+
+	// The IKS2 Ingress has no 'example.com/target-type' annotation, so no pod readiness gate is required
+	app := &GuardContext{Resources: toResourceDiffs(t, IKS2Ingress), Config: config}
+	findings, err := LookupGuard("ingress").Evaluate(app)
+	assert.Nil(t, err)
+	assert.Empty(t, findings)
+}
+
+func TestShippedGuardConfig(t *testing.T) {
+	config, err := loadGuardConfig(filepath.Join("..", "..", defaultGuardConfigPath))
+	assert.Nil(t, err)
+	assert.True(t, config.enabled("hpa"))
+	assert.Equal(t, defaultIngressTargetTypeAnnotation, config.setting("ingress", "targetTypeAnnotation", ""))
+}
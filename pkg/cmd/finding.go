@@ -0,0 +1,180 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+// Severity tells how bad a finding is, only "error" findings fail the guard
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+// Exit status of the guard commands, decided once all guards have run
+const (
+	exitCodeOK         = 0
+	exitCodeViolations = 1
+	exitCodeGuardError = 2
+
+	// The sync command fails with these once the guards passed
+	exitCodeSyncFailed = 3
+	exitCodeUnhealthy  = 4
+	exitCodeTimeout    = 5
+)
+
+// Finding is a single problem a guard found on a resource
+type Finding struct {
+	// App is only set in the combined report of many applications
+	App       string   `json:"app,omitempty"`
+	Guard     string   `json:"guard"`
+	Rule      string   `json:"rule"`
+	Severity  Severity `json:"severity"`
+	Group     string   `json:"group,omitempty"`
+	Version   string   `json:"version,omitempty"`
+	Kind      string   `json:"kind,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Message   string   `json:"message"`
+	Fix       string   `json:"fix,omitempty"`
+
+	// Documentation is a link explaining the remediation
+	Documentation string `json:"documentation,omitempty"`
+
+	// Suppression is only set on the findings bypassed by the skip annotations
+	Suppression *Suppression `json:"suppression,omitempty"`
+
+	// Change tells whether the finding is new, existing or fixed compared with the live state of the application
+	Change string `json:"change,omitempty"`
+}
+
+// newFinding creates a finding on the given object, obj could be nil if the finding isn't about a single resource
+func newFinding(rule string, severity Severity, obj *unstructured.Unstructured, message string, fix string) Finding {
+	finding := Finding{
+		Rule:     rule,
+		Severity: severity,
+		Message:  message,
+		Fix:      fix,
+	}
+	if obj != nil {
+		gvk := obj.GroupVersionKind()
+		finding.Group = gvk.Group
+		finding.Version = gvk.Version
+		finding.Kind = gvk.Kind
+		finding.Namespace = obj.GetNamespace()
+		finding.Name = obj.GetName()
+	}
+	return finding
+}
+
+// newResourceFinding creates a finding on a resource of the application
+func newResourceFinding(rule string, severity Severity, resource *argoappv1.ResourceDiff, message string, fix string) Finding {
+	finding := newFinding(rule, severity, nil, message, fix)
+	finding.Group = resource.Group
+	finding.Kind = resource.Kind
+	finding.Namespace = resource.Namespace
+	finding.Name = resource.Name
+	if target, err := resource.TargetObject(); err == nil && target != nil {
+		finding.Version = target.GroupVersionKind().Version
+	} else if live, err := resource.LiveObject(); err == nil && live != nil {
+		finding.Version = live.GroupVersionKind().Version
+	}
+	return finding
+}
+
+// Resource returns the resource of the finding in "Kind:namespace/name" format
+func (f Finding) Resource() string {
+	if f.Kind == "" && f.Name == "" {
+		return ""
+	}
+	gk := f.Kind
+	if f.Group != "" {
+		gk = f.Kind + "." + f.Group
+	}
+	if f.Namespace == "" {
+		return fmt.Sprintf("%s:%s", gk, f.Name)
+	}
+	return fmt.Sprintf("%s:%s/%s", gk, f.Namespace, f.Name)
+}
+
+// String formats the finding as a single log line
+func (f Finding) String() string {
+	var b strings.Builder
+	if f.App != "" {
+		fmt.Fprintf(&b, "%s: ", f.App)
+	}
+	fmt.Fprintf(&b, "[%s/%s]", f.Guard, f.Rule)
+	if f.Change != "" {
+		fmt.Fprintf(&b, " (%s)", f.Change)
+	}
+	if resource := f.Resource(); resource != "" {
+		fmt.Fprintf(&b, " %s", resource)
+	}
+	fmt.Fprintf(&b, " %s", f.Message)
+	if f.Fix != "" {
+		fmt.Fprintf(&b, " Fix: %s", f.Fix)
+	}
+	if f.Documentation != "" {
+		fmt.Fprintf(&b, " See: %s", f.Documentation)
+	}
+	return b.String()
+}
+
+// hasErrors returns true if any of the findings has severity "error"
+func hasErrors(findings []Finding) bool {
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// logFindings writes every finding to the log with the level matching its severity
+func logFindings(findings []Finding) {
+	for _, finding := range findings {
+		switch finding.Severity {
+		case SeverityError:
+			log.Error(finding.String())
+		case SeverityWarning:
+			log.Warn(finding.String())
+		default:
+			log.Info(finding.String())
+		}
+	}
+}
+
+// exitCode decides the exit status of the command once all guards have run
+func exitCode(findings []Finding, guardErrors int) int {
+	if hasErrors(findings) {
+		return exitCodeViolations
+	}
+	if guardErrors > 0 {
+		return exitCodeGuardError
+	}
+	return exitCodeOK
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindingString(t *testing.T) {
+	finding := Finding{
+		Guard:     "hpa",
+		Rule:      "hpa-replicas-set",
+		Severity:  SeverityError,
+		Group:     "apps",
+		Kind:      "Deployment",
+		Namespace: "my-ns",
+		Name:      "my-deployment",
+		Message:   "'spec.replicas' is set.",
+		Fix:       "Delete 'spec.replicas'.",
+	}
+	assert.Equal(t, "Deployment.apps:my-ns/my-deployment", finding.Resource())
+	assert.Equal(t, "[hpa/hpa-replicas-set] Deployment.apps:my-ns/my-deployment 'spec.replicas' is set. Fix: Delete 'spec.replicas'.", finding.String())
+}
+
+func TestExitCode(t *testing.T) {
+	warning := Finding{Severity: SeverityWarning}
+	failure := Finding{Severity: SeverityError}
+	assert.Equal(t, exitCodeOK, exitCode(nil, 0))
+	assert.Equal(t, exitCodeOK, exitCode([]Finding{warning}, 0))
+	assert.Equal(t, exitCodeViolations, exitCode([]Finding{warning, failure}, 1))
+	assert.Equal(t, exitCodeGuardError, exitCode([]Finding{warning}, 1))
+}
@@ -19,21 +19,24 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
-	"reflect"
-	"strconv"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/argoproj/argo-cd/util"
 	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/tools/clientcmd/api"
 
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 
 	argocdclient "github.com/argoproj/argo-cd/pkg/apiclient"
 	"github.com/argoproj/argo-cd/pkg/apiclient/application"
+	projectpkg "github.com/argoproj/argo-cd/pkg/apiclient/project"
 	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
 	log "github.com/sirupsen/logrus"
 )
@@ -47,8 +50,14 @@ var (
 	# Guard given aspect'
 	%[1]s <aspect>
 
+	# Guard all applications of a team in dry run'
+	%[1]s all --selector team=payments --dryRun
+
     # Verify given aspect, but won't change anything'
     %[1]s <aspect> --dryRun
+
+	# Guard manifests against the live objects of the cluster in the current kubeconfig context'
+	%[1]s <aspect> --source kube --manifests rendered/ --context my-cluster
     `
 
 	//errNoContext = fmt.Errorf("no context is currently set, use %q to select a new one", "kubectl config use-context <context>")
@@ -77,13 +86,44 @@ type GuardOptions struct {
 
 // NewGuardOptions provides an instance of GuardOptions with default values
 func NewGuardOptions(clientOpts *argocdclient.ClientOptions) *GuardOptions {
+	configFlags := genericclioptions.NewConfigFlags()
+	//--server is the Argo CD server
+	configFlags.APIServer = nil
 	return &GuardOptions{
-		configFlags: genericclioptions.NewConfigFlags(),
+		configFlags: configFlags,
 
 		clientOpts: clientOpts,
 	}
 }
 
+// complete resolves the kubeconfig context, cluster and user the guards talk to in direct Kubernetes mode
+func (o *GuardOptions) complete() error {
+	var err error
+	o.rawConfig, err = o.configFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return err
+	}
+	o.userSpecifiedContext = *o.configFlags.Context
+	o.userSpecifiedCluster = *o.configFlags.ClusterName
+	o.userSpecifiedAuthInfo = *o.configFlags.AuthInfoName
+
+	o.resultingContextName = o.userSpecifiedContext
+	if o.resultingContextName == "" {
+		o.resultingContextName = o.rawConfig.CurrentContext
+	}
+	o.resultingContext = api.NewContext()
+	if context, ok := o.rawConfig.Contexts[o.resultingContextName]; ok {
+		o.resultingContext = context.DeepCopy()
+	}
+	if o.userSpecifiedCluster != "" {
+		o.resultingContext.Cluster = o.userSpecifiedCluster
+	}
+	if o.userSpecifiedAuthInfo != "" {
+		o.resultingContext.AuthInfo = o.userSpecifiedAuthInfo
+	}
+	return nil
+}
+
 // NewCmdGuard provides a cobra command wrapping GuardOptions
 func NewCmdGuard(clientOpts *argocdclient.ClientOptions) *cobra.Command {
 	o := NewGuardOptions(clientOpts)
@@ -98,9 +138,13 @@ func NewCmdGuard(clientOpts *argocdclient.ClientOptions) *cobra.Command {
 		},
 	}
 
-	cmd.AddCommand(NewGuardHpaCommand(clientOpts))
-	cmd.AddCommand(NewGuardIngressCommand(clientOpts))
-	cmd.AddCommand(NewGuardAllCommand(clientOpts))
+	for _, guard := range Guards() {
+		cmd.AddCommand(NewGuardCommand(guard, o))
+	}
+	cmd.AddCommand(NewGuardAllCommand(o))
+	cmd.AddCommand(NewWebhookCommand(o))
+	cmd.AddCommand(NewSyncCommand(o))
+	cmd.AddCommand(NewUndoCommand(o))
 
 	cmd.Flags().BoolVar(&o.dryRun, "dryRun", o.dryRun, "if true, guard just verify, won't make any change")
 
@@ -115,82 +159,6 @@ func NewCmdGuard(clientOpts *argocdclient.ClientOptions) *cobra.Command {
 	return cmd
 }
 
-func verifyHpa(resourceDiffs []*argoappv1.ResourceDiff) ([]*unstructured.Unstructured, []string, map[string]*argoappv1.ResourceDiff, int) {
-	hpas, resourceNames, resources := hpaReferencesObjects(resourceDiffs)
-
-	if len(hpas) == 0 {
-		log.Infof("No HPA found, good to pass through")
-		return nil, nil, nil, 200
-	}
-
-	for i := range resourceNames {
-		resourceName := resourceNames[i]
-		resource := resources[resourceName]
-		if resource == nil {
-			log.Errorf("The HPA:%s refer to a non-exists resource: %s", hpas[i].GetName(), resourceName)
-			os.Exit(301)
-			return nil, nil, nil, 301
-		}
-
-		resourceTarget, error := resource.TargetObject()
-		if error != nil || resourceTarget == nil {
-			log.Errorf("The target object %s doesn't exist or has error %v", resourceName, error)
-			return nil, nil, nil, 200
-		}
-		if error == nil {
-			specObj := resourceTarget.Object["spec"]
-			if specObj != nil && reflect.TypeOf(specObj).String() == "map[string]interface {}" {
-				spec := specObj.(map[string]interface{})
-				if spec["replicas"] != nil {
-					log.Errorf("Please set 'spec.replicas' as null ('replicas: null') in %s:%s for kustomize template or delete 'spec.replicas' if you use ksonnet, since the replicas is managed by HPA:%s", resource.Kind, resourceName, hpas[i].GetName())
-					os.Exit(302)
-					return nil, nil, nil, 301
-				}
-			}
-		}
-
-		resourceLive, error := resource.LiveObject()
-		if error != nil {
-			log.Errorf("The live object has error %v", error)
-			return nil, nil, nil, 200
-		}
-		if error == nil {
-			if resourceLive == nil { //No object, first time roll out
-				delete(resources, resourceName)
-				resourceNames[i] = ""
-				continue
-			}
-
-			var metadataObj = resourceLive.Object["metadata"]
-			if metadataObj != nil && reflect.TypeOf(metadataObj).String() == "map[string]interface {}" {
-				metadata := metadataObj.(map[string]interface{})
-				if metadata["annotations"] != nil && reflect.TypeOf(metadata["annotations"]).String() == "map[string]interface {}" {
-					annotations := metadata["annotations"].(map[string]interface{})
-
-					var lastAppliedConfiguration = annotations["kubectl.kubernetes.io/last-applied-configuration"]
-					if lastAppliedConfiguration != "" {
-						var resourceLastApplied = &unstructured.Unstructured{}
-						err := json.Unmarshal([]byte(lastAppliedConfiguration.(string)), resourceLastApplied)
-						if err == nil {
-							specObj := resourceLastApplied.Object["spec"]
-							if specObj != nil && reflect.TypeOf(specObj).String() == "map[string]interface {}" {
-								spec := specObj.(map[string]interface{})
-								if spec["replicas"] == nil { //The Deployment/Rollout doesn't have 'spec.replicas' it is in good state
-									log.Infof("%s:%s doesn't have 'spec.replicas', it is managed by HPA, perfect!", resource.Kind, resourceName)
-									delete(resources, resourceName)
-									resourceNames[i] = ""
-									continue
-								}
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-	return hpas, resourceNames, resources, 0
-}
-
 func getRefreshType(refresh bool, hardRefresh bool) *string {
 	if hardRefresh {
 		refreshType := string(argoappv1.RefreshTypeHard)
@@ -205,513 +173,486 @@ func getRefreshType(refresh bool, hardRefresh bool) *string {
 	return nil
 }
 
-// NewGuardAllCommand returns a new instance of an `argocd app create` command
-func NewGuardAllCommand(clientOpts *argocdclient.ClientOptions) *cobra.Command {
-	var dryRun bool
-	var timeout uint
+const defaultCheckTimeoutSeconds = 0
 
-	var allCmd = &cobra.Command{
-		Use:     "all [flags]",
-		Short:   "Execute all guards",
-		Example: fmt.Sprintf(guardExample, "guard all"),
-		Run: func(c *cobra.Command, args []string) {
-			for _, cmd := range c.Parent().Commands() {
-				if cmd.Name() != "all" && cmd.Name() != "help" {
-					cmd.Run(cmd, args)
-				}
-			}
-		},
+// pollInterval is how often the application is fetched while waiting for Argo CD
+var pollInterval = 2 * time.Second
+
+// withTimeout returns a context which is cancelled after timeout seconds, 0 means no timeout
+func withTimeout(ctx context.Context, timeout uint) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+}
+
+// NewGuardAllCommand returns a command which executes all registered guards
+func NewGuardAllCommand(o *GuardOptions) *cobra.Command {
+	return newGuardCommand("all <App Name>", "Execute all guards", enabledGuards, o)
+}
+
+// NewGuardCommand returns a command which executes the given guard
+func NewGuardCommand(guard Guard, o *GuardOptions) *cobra.Command {
+	return newGuardCommand(guard.Name()+" <App Name>", guard.Description(), func(*GuardConfig) []Guard {
+		return []Guard{guard}
+	}, o)
+}
+
+// enabledGuards returns the registered guards which are not disabled in the guard config
+func enabledGuards(config *GuardConfig) []Guard {
+	result := make([]Guard, 0)
+	for _, guard := range Guards() {
+		if config.enabled(guard.Name()) {
+			result = append(result, guard)
+		} else {
+			log.Infof("Guard '%s' is disabled in guard config", guard.Name())
+		}
+	}
+	return result
+}
+
+// Sources of the live objects
+const (
+	sourceArgoCD = "argocd"
+	sourceKube   = "kube"
+)
+
+// guardRunOptions are the flags shared by all guard commands
+type guardRunOptions struct {
+	// source tells where the live objects come from, the desired manifests are given by --manifests in kube source
+	source string
+
+	dryRun      bool
+	timeout     uint
+	hardRefresh bool
+	production  bool
+
+	// manifests and liveManifests switch the command to offline mode, no Argo CD server is needed
+	manifests     string
+	liveManifests string
+
+	// emitPatches is a directory the patches are written to for review instead of applying them
+	emitPatches string
+	// journal records the applied patches of Argo CD applications, so `undo` could revert them
+	journal string
+
+	// failOn is "new" if only the findings introduced by the change should fail the guards
+	failOn string
+
+	// output is the format of the report, reportFile is where it is written to, stdout by default
+	output     string
+	reportFile string
+
+	// guardConfig is the path of the guard config, config is the loaded one
+	guardConfig string
+	config      *GuardConfig
+
+	// selector, projects and allApps guard many Argo CD applications in one run instead of the named one
+	selector string
+	projects []string
+	allApps  bool
+}
+
+// patcher returns the patcher and dry run of a guard context, with --emit-patches the patches
+// are written for review instead of being applied. Without a patcher it always runs as dry run.
+func (o *guardRunOptions) patcher(appName string, patcher Patcher) (Patcher, bool) {
+	if o.emitPatches != "" {
+		_, argoCD := patcher.(*argoCDPatcher)
+		return &patchWriter{dir: o.emitPatches, appName: appName, argoCD: argoCD}, false
 	}
-	allCmd.Flags().BoolVar(&dryRun, "dryRun", false, "If true, it won't make any changes.")
-	allCmd.Flags().UintVar(&timeout, "timeout", defaultCheckTimeoutSeconds, "Time out after this many seconds")
-	allCmd.Flags().ParseErrorsWhitelist.UnknownFlags = true
-	allCmd.FParseErrWhitelist.UnknownFlags = true
-	return allCmd
+	if _, argoCD := patcher.(*argoCDPatcher); argoCD && o.journal != "" && !o.dryRun {
+		return &journalPatcher{patcher: patcher, journal: o.journal, appName: appName}, false
+	}
+	return patcher, o.dryRun || patcher == nil
 }
 
-// NewGuardHpaCommand returns a new instance of an `argocd app create` command
-func NewGuardHpaCommand(clientOpts *argocdclient.ClientOptions) *cobra.Command {
-	var dryRun bool
-	var timeout uint
+// multipleApps tells whether the applications are listed from Argo CD instead of given by name
+func (o *guardRunOptions) multipleApps() bool {
+	return o.selector != "" || len(o.projects) != 0 || o.allApps
+}
+
+func newGuardCommand(use string, short string, guardsToRun func(*GuardConfig) []Guard, o *GuardOptions) *cobra.Command {
+	var opts guardRunOptions
 	var command = &cobra.Command{
-		Use:   "hpa <App Name>",
-		Short: "Check HPA and specs of objects refereneced by HPA",
+		Use:   use,
+		Short: short,
 	}
 
 	command.Run = func(c *cobra.Command, args []string) {
-		var appName string
-		if len(args) > 1 {
-			for i := range args {
-				if strings.HasPrefix(args[i], "--") {
-					continue
-				} else {
-					appName = args[i]
-					break
-				}
-			}
-			if appName == "" {
-				c.HelpFunc()(c, args)
-				os.Exit(1)
-			}
-		} else if len(args) == 1 {
-			appName = args[0]
-		} else {
-			c.HelpFunc()(c, args)
-			os.Exit(1)
+		if err := validateOutput(opts.output); err != nil {
+			log.Error(err)
+			os.Exit(exitCodeGuardError)
 		}
-
-		clientOpts.Insecure = true
-		apiClient := argocdclient.NewClientOrDie(clientOpts)
-		conn, appIf := apiClient.NewApplicationClientOrDie()
-		defer util.Close(conn)
-		ctx := context.Background()
-		_, err := appIf.Get(context.Background(), &application.ApplicationQuery{Name: &appName, Refresh: getRefreshType(true, false)})
-		if err != nil {
+		if err := validateFailOn(opts.failOn); err != nil {
 			log.Error(err)
-			return
+			os.Exit(exitCodeGuardError)
 		}
-		resourceDiffs, err := appIf.ManagedResources(ctx, &application.ResourcesQuery{ApplicationName: &appName})
+		config, err := loadGuardConfig(opts.guardConfig)
 		if err != nil {
 			log.Error(err)
-			return
+			os.Exit(exitCodeGuardError)
 		}
-
-		_, resourceNames, resources, statusCode := verifyHpa(resourceDiffs.Items)
-
-		if statusCode != 0 {
-			return
+		opts.config = config
+
+		appName := appNameFromArgs(args)
+		switch opts.source {
+		case sourceKube:
+			os.Exit(runGuardsOnCluster(o, appName, guardsToRun(config), opts))
+		case sourceArgoCD:
+		default:
+			log.Errorf("unknown source '%s', should be one of: %s|%s", opts.source, sourceArgoCD, sourceKube)
+			os.Exit(exitCodeGuardError)
+		}
+		if opts.manifests != "" {
+			os.Exit(runGuardsOnManifests(appName, guardsToRun(config), opts))
+		}
+		if opts.multipleApps() {
+			if appName != "" {
+				log.Errorf("Application name %s can't be used together with --selector, --project or --all-apps", appName)
+				os.Exit(exitCodeGuardError)
+			}
+			os.Exit(runGuardsOnApps(o.clientOpts, guardsToRun(config), opts))
+		}
+		if appName == "" {
+			c.HelpFunc()(c, args)
+			os.Exit(1)
 		}
 
-		//Apply patches
-		applyLastAppliedConfigPatch(ctx, appIf, appName, resourceNames, resources, dryRun)
+		os.Exit(runGuards(o.clientOpts, appName, guardsToRun(config), opts))
 	}
-	command.Flags().BoolVar(&dryRun, "dryRun", false, "If true, it won't make any changes.")
-	command.Flags().UintVar(&timeout, "timeout", defaultCheckTimeoutSeconds, "Time out after this many seconds")
+	command.Flags().StringVar(&opts.source, "source", sourceArgoCD, "Where the live objects come from. One of: argocd|kube, kube reads them from the cluster of the kubeconfig context")
+	command.Flags().BoolVar(&opts.dryRun, "dryRun", false, "If true, it won't make any changes.")
+	command.Flags().UintVar(&opts.timeout, "timeout", defaultCheckTimeoutSeconds, "Time out after this many seconds guarding an application, 0 waits forever")
+	command.Flags().BoolVar(&opts.hardRefresh, "hard-refresh", false, "Regenerate the manifests of the application instead of using the Argo CD cache before guarding it")
+	command.Flags().BoolVar(&opts.production, "production", false, "Guard the application as a production application, by default it is decided by the application name suffix '-prd', '-prod' or '-production'")
+	command.Flags().StringVar(&opts.manifests, "manifests", "", "Guard local YAML/JSON manifests (file, directory or '-' for stdin) instead of an Argo CD application")
+	command.Flags().StringVar(&opts.liveManifests, "live-manifests", "", "File or directory with the manifests of the live objects, used together with --manifests")
+	command.Flags().StringVar(&opts.journal, "journal", defaultJournalPath(), "Record the patches of Argo CD applications in this journal, revert them with the undo command")
+	command.Flags().StringVar(&opts.emitPatches, "emit-patches", "", "Write the patches with the argocd/kubectl commands applying them to this directory instead of applying them")
+	command.Flags().StringVar(&opts.failOn, "fail-on", failOnAll, "Which error findings fail the guards. One of: all|new, new ignores the findings the live state already has")
+	command.Flags().StringVarP(&opts.output, "output", "o", outputText, "Output format of the findings. One of: text|json|junit|sarif")
+	command.Flags().StringVar(&opts.reportFile, "report-file", "", "Write the json|junit|sarif report to this file instead of stdout")
+	command.Flags().StringVar(&opts.guardConfig, "guard-config", "", "Path of the guard config, "+defaultGuardConfigPath+" is used if it exists")
+	command.Flags().StringVarP(&opts.selector, "selector", "l", "", "Guard all applications matching this label selector, e.g. team=payments")
+	command.Flags().StringArrayVar(&opts.projects, "project", nil, "Guard all applications of this project, could be repeated")
+	command.Flags().BoolVar(&opts.allApps, "all-apps", false, "Guard all applications")
 	command.Flags().ParseErrorsWhitelist.UnknownFlags = true
 	command.PersistentFlags().ParseErrorsWhitelist.UnknownFlags = true
 	command.FParseErrWhitelist.UnknownFlags = true
+	o.configFlags.AddFlags(command.Flags())
 
 	return command
 }
 
-//Call ArgoCD patch to apply "kubectl.kubernetes.io/last-applied-configuration" patch on DeploymentSpec and RolloutSpec
-func applyLastAppliedConfigPatch(ctx context.Context, appIf application.ApplicationServiceClient, appName string, resourceNames []string, resources map[string]*argoappv1.ResourceDiff, dryRun bool) {
-	if len(resources) != 0 { //
-		//The remain deployments or rollouts need to be applied
-		for i := range resourceNames {
-			resourceName := resourceNames[i]
-			if resourceName != "" {
-				var resource = resources[resourceName]
-				var namespace = resource.Namespace
-				var liveObj, _ = resource.LiveObject()
-				liveObjCopy := liveObj.DeepCopy()
-				if namespace == "" {
-					namespace = liveObj.GetNamespace()
-				}
-
-				var lastAppliedConfig = ""
-
-				metadataObj := liveObjCopy.Object["metadata"]
-				if metadataObj != nil && reflect.TypeOf(metadataObj).String() == "map[string]interface {}" {
-					metadata := metadataObj.(map[string]interface{})
-					annoObj := metadata["annotations"]
-					if annoObj != nil && reflect.TypeOf(annoObj).String() == "map[string]interface {}" {
-						anno := annoObj.(map[string]interface{})
-						lastAppliedConfig = anno["kubectl.kubernetes.io/last-applied-configuration"].(string)
-						delete(anno, "kubectl.kubernetes.io/last-applied-configuration")
-					}
-				}
-
-				var lastAppliedConfigObj *unstructured.Unstructured = nil
-				if lastAppliedConfig != "" { //Use the last-applied-configuration instead of liveObject
-					lastAppliedConfigObj = &unstructured.Unstructured{}
-					err := json.Unmarshal([]byte(lastAppliedConfig), lastAppliedConfigObj)
-					if err != nil {
-						log.Errorf("Not able to unmarshal last-applied-configuration %s %v", lastAppliedConfig, err)
-					}
-					liveObjCopy = lastAppliedConfigObj
-				}
-
-				specObj := liveObjCopy.Object["spec"]
-				if specObj != nil && reflect.TypeOf(specObj).String() == "map[string]interface {}" {
-					spec := specObj.(map[string]interface{})
-					delete(spec, "replicas")
-				}
-				delete(liveObjCopy.Object, "status")
-
-				bytes, err := json.Marshal(liveObjCopy)
-				if err != nil {
-					log.Errorf("Not able to marshal %s Spec", liveObjCopy.GetKind())
-					os.Exit(1)
-				}
-
-				newPatch := make(map[string]interface{})
-				newMetadata := make(map[string]interface{})
-				newPatch["metadata"] = newMetadata
-				newAnnotations := make(map[string]interface{})
-				newMetadata["annotations"] = newAnnotations
-				newAnnotations["kubectl.kubernetes.io/last-applied-configuration"] = string(bytes)
-
-				//For debug
-				//var tmpFileName = "/tmp/" + resourceName + strconv.FormatInt(time.Now().Unix(), 10) + ".yaml"
-				//f, err := os.Create(tmpFileName)
-				//if err != nil {
-				//	log.Errorf("Not able to create temp file:%s", tmpFileName)
-				//	os.Exit(1)
-				//}
-				////log.Infof("Writing current resource to yaml file:%s", tmpFileName)
-				yamlBytes, err := json.Marshal(newPatch)
-				//f.Write(yamlBytes)
-				//f.Sync()
-				//f.Close()
-
-				//var fileNames = make([]string, 1)
-				//fileNames[0] = tmpFileName
-
-				if !dryRun {
-					_, err = appIf.PatchResource(ctx, &application.ApplicationResourcePatchRequest{
-						Name:         &appName,
-						Namespace:    namespace,
-						ResourceName: resourceName,
-						Version:      liveObjCopy.GetAPIVersion(),
-						Group:        resource.Group,
-						Kind:         resource.Kind,
-						Patch:        string(yamlBytes),
-						PatchType:    "application/merge-patch+json",
-					})
-					if err != nil {
-						log.Errorf("Patching annoation 'kubectl.kubernetes.io/last-applied-configuration' on resource: %s, error:%v", resourceName, err)
-					} else {
-						log.Infof("Resource '%s' patched on 'kubectl.kubernetes.io/last-applied-configuration'", resourceName)
-					}
-				} else {
-					log.Infof("DryRun on resource '%s' patch of 'kubectl.kubernetes.io/last-applied-configuration'", resourceName)
-				}
-			}
+// appNameFromArgs returns the first argument which is not a flag, unknown flags are passed through as arguments
+func appNameFromArgs(args []string) string {
+	for i := range args {
+		if !strings.HasPrefix(args[i], "--") {
+			return args[i]
 		}
 	}
+	return ""
 }
 
-// hpaReferencesObjects finds all the resources that the HPA spec references
-func hpaReferencesObjects(resourceDiffs []*argoappv1.ResourceDiff) ([]*unstructured.Unstructured, []string, map[string]*argoappv1.ResourceDiff) {
-	hpaObjects := make([]*unstructured.Unstructured, 0)
-	resourceNames := make([]string, 0)
-	resources := make(map[string]*argoappv1.ResourceDiff)
-
-	for i := range resourceDiffs {
-		obj := resourceDiffs[i]
-		if obj.Kind == "HorizontalPodAutoscaler" && obj.Group == "autoscaling" {
-			targetObject, error := obj.TargetObject()
-			if error == nil && targetObject != nil {
-				specObj := targetObject.Object["spec"]
-				if specObj != nil && reflect.TypeOf(specObj).String() == "map[string]interface {}" {
-					spec := specObj.(map[string]interface{})
-					scaleTargetRefObj := spec["scaleTargetRef"]
-					if scaleTargetRefObj != nil && reflect.TypeOf(scaleTargetRefObj).String() == "map[string]interface {}" {
-						scaleTargetRef := scaleTargetRefObj.(map[string]interface{})
-						if scaleTargetRef["kind"] == "Deployment" || scaleTargetRef["kind"] == "Rollout" {
-							copy := targetObject.DeepCopy()
-							hpaObjects = append(hpaObjects, copy)
-							var resourceName = scaleTargetRef["name"].(string)
-							resourceNames = append(resourceNames, resourceName)
-
-							log.Infof("The HorizontalPodAutoscaler:%s is associated with %s:%s", targetObject.GetName(), scaleTargetRef["kind"], resourceName)
-						}
-					}
-				}
-			}
-		} else if obj.Kind == "Deployment" {
-			resources[obj.Name] = obj.DeepCopy()
-		} else if obj.Kind == "Rollout" && obj.Group == "argoproj.io" {
-			resources[obj.Name] = obj.DeepCopy()
-		}
-	}
-	return hpaObjects, resourceNames, resources
+// newApplicationClient connects to the Argo CD server
+func newApplicationClient(clientOpts *argocdclient.ClientOptions) (io.Closer, application.ApplicationServiceClient) {
+	clientOpts.Insecure = true
+	apiClient := argocdclient.NewClientOrDie(clientOpts)
+	return apiClient.NewApplicationClientOrDie()
 }
 
-const defaultCheckTimeoutSeconds = 0
+// newProjectClient connects to the Argo CD server to read the projects of the applications
+func newProjectClient(clientOpts *argocdclient.ClientOptions) (io.Closer, projectpkg.ProjectServiceClient) {
+	clientOpts.Insecure = true
+	apiClient := argocdclient.NewClientOrDie(clientOpts)
+	return apiClient.NewProjectClientOrDie()
+}
 
-// NewGuardIngressCommand is to enforce Deployment object has "PodReadinessCondition for ALB-Ingress" when target-type is "ip" in Ingress
-func NewGuardIngressCommand(clientOpts *argocdclient.ClientOptions) *cobra.Command {
-	var dryRun bool
-	var timeout uint
-	var command = &cobra.Command{
-		Use:   "ingress <App Name>",
-		Short: "Check Ingress and Deployment",
+// runGuards guards the named application and returns the exit status of the command
+func runGuards(clientOpts *argocdclient.ClientOptions, appName string, guardsToRun []Guard, opts guardRunOptions) int {
+	conn, appIf := newApplicationClient(clientOpts)
+	defer util.Close(conn)
+	projConn, projIf := newProjectClient(clientOpts)
+	defer util.Close(projConn)
+	ctx, cancel := withTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	report, err := guardApp(ctx, appIf, projIf, appName, guardsToRun, opts)
+	if err != nil {
+		log.Errorf("Not able to guard application %s: %v", appName, err)
+		return exitCodeGuardError
 	}
+	return finishReport(report, opts)
+}
 
-	command.Run = func(c *cobra.Command, args []string) {
-		var appName string
-		if len(args) > 1 {
-			for i := range args {
-				if strings.HasPrefix(args[i], "--") {
-					continue
-				} else {
-					appName = args[i]
-					break
-				}
-			}
-			if appName == "" {
-				c.HelpFunc()(c, args)
-				os.Exit(1)
-			}
-		} else if len(args) == 1 {
-			appName = args[0]
-		} else {
-			c.HelpFunc()(c, args)
-			os.Exit(1)
-		}
+// runGuardsOnApps guards every application matching --selector, --project or --all-apps and publishes a combined report.
+// An application which can't be guarded doesn't stop the others.
+func runGuardsOnApps(clientOpts *argocdclient.ClientOptions, guardsToRun []Guard, opts guardRunOptions) int {
+	conn, appIf := newApplicationClient(clientOpts)
+	defer util.Close(conn)
+	projConn, projIf := newProjectClient(clientOpts)
+	defer util.Close(projConn)
+	listCtx, cancel := withTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	apps, err := appIf.List(listCtx, &application.ApplicationQuery{Projects: opts.projects})
+	if err != nil {
+		log.Errorf("Not able to list applications: %v", err)
+		return exitCodeGuardError
+	}
+	appNames, err := selectApps(apps.Items, opts.selector)
+	if err != nil {
+		log.Errorf("Invalid selector %s: %v", opts.selector, err)
+		return exitCodeGuardError
+	}
+	if len(appNames) == 0 {
+		log.Warnf("No application matches, nothing to guard")
+	}
 
-		clientOpts.Insecure = true
-		apiClient := argocdclient.NewClientOrDie(clientOpts)
-		conn, appIf := apiClient.NewApplicationClientOrDie()
-		defer util.Close(conn)
-		ctx := context.Background()
-		_, err := appIf.Get(context.Background(), &application.ApplicationQuery{Name: &appName, Refresh: getRefreshType(true, false)})
+	report := newCombinedReport()
+	report.failOn = opts.failOn
+	for _, appName := range appNames {
+		log.Infof("Guarding application %s", appName)
+		ctx, cancel := withTimeout(context.Background(), opts.timeout)
+		appReport, err := guardApp(ctx, appIf, projIf, appName, guardsToRun, opts)
+		cancel()
 		if err != nil {
-			log.Error(err)
-			return
+			log.Errorf("Not able to guard application %s: %v", appName, err)
+			report.addAppError(appName, err)
+			continue
 		}
-		resourceDiffs, err := appIf.ManagedResources(ctx, &application.ResourcesQuery{ApplicationName: &appName})
-		if err != nil {
-			log.Error(err)
-			return
+		report.addApp(appReport)
+	}
+	return finishReport(report, opts)
+}
+
+// selectApps returns the sorted names of the applications whose labels match the selector, an empty selector matches all
+func selectApps(apps []argoappv1.Application, selector string) ([]string, error) {
+	labelSelector, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, app := range apps {
+		if labelSelector.Matches(labels.Set(app.Labels)) {
+			names = append(names, app.Name)
 		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
 
-		statusCode := verifyIngress(resourceDiffs.Items)
+// guardApp refreshes the application, fetches its managed resources once and hands them to every guard
+func guardApp(ctx context.Context, appIf application.ApplicationServiceClient, projIf projectpkg.ProjectServiceClient, appName string, guardsToRun []Guard, opts guardRunOptions) (*Report, error) {
+	argoApp, err := refreshApp(ctx, appIf, appName, opts.hardRefresh)
+	if err != nil {
+		return nil, err
+	}
+	resourceDiffs, err := appIf.ManagedResources(ctx, &application.ResourcesQuery{ApplicationName: &appName})
+	if err != nil {
+		return nil, err
+	}
 
-		if statusCode != 0 {
-			return
-		}
+	patcher, dryRun := opts.patcher(appName, &argoCDPatcher{appIf: appIf, appName: appName})
+	app := &GuardContext{
+		Ctx:         ctx,
+		AppName:     appName,
+		Resources:   resourceDiffs.Items,
+		AppIf:       appIf,
+		Application: argoApp,
+		Project:     appProject(ctx, projIf, argoApp),
+		Patcher:     patcher,
+		DryRun:      dryRun,
+		Config:      opts.config,
+		Production:  opts.production || isProductionApp(appName),
 	}
-	command.Flags().BoolVar(&dryRun, "dryRun", false, "If true, it won't make any changes.")
-	command.Flags().UintVar(&timeout, "timeout", defaultCheckTimeoutSeconds, "Time out after this many seconds")
-	command.Flags().ParseErrorsWhitelist.UnknownFlags = true
-	command.PersistentFlags().ParseErrorsWhitelist.UnknownFlags = true
-	command.FParseErrWhitelist.UnknownFlags = true
+	return opts.evaluate(app, guardsToRun), nil
+}
 
-	return command
+// appProject returns the AppProject of the application, or nil if there is no project client or the project can't be read
+func appProject(ctx context.Context, projIf projectpkg.ProjectServiceClient, app *argoappv1.Application) *argoappv1.AppProject {
+	if projIf == nil {
+		return nil
+	}
+	project, err := projIf.Get(ctx, &projectpkg.ProjectQuery{Name: app.Spec.GetProject()})
+	if err != nil {
+		log.Warnf("Not able to get project %s of application %s, skipping the project checks: %v", app.Spec.GetProject(), app.Name, err)
+		return nil
+	}
+	return project
 }
 
-func verifyIngress(resourceDiffs []*argoappv1.ResourceDiff) int {
-	ingresses, resources := ingressAndDeployment(resourceDiffs)
-	if len(ingresses) == 0 {
-		log.Infof("No Ingress found, good to pass through")
-		return 0
-	}
-
-	// Ingress Name -->  True or False
-	podReadinessGateEnabled := make(map[string]bool)
-	ingressMap := make(map[string]*unstructured.Unstructured)
-
-	// Set the mapping to false if the ingress has annotation alb.ingress.kubernetes.io/target-type=ip
-	for i := range ingresses {
-		ingress := ingresses[i]
-		ingressName := ingress.GetName()
-		var metadataObj = ingress.Object["metadata"]
-		if metadataObj != nil && reflect.TypeOf(metadataObj).String() == "map[string]interface {}" {
-			metadata := metadataObj.(map[string]interface{})
-			if metadata["annotations"] != nil && reflect.TypeOf(metadata["annotations"]).String() == "map[string]interface {}" {
-				annotations := metadata["annotations"].(map[string]interface{})
-
-				var lastAppliedConfiguration = annotations["alb.ingress.kubernetes.io/target-type"]
-				if lastAppliedConfiguration == "ip" {
-					podReadinessGateEnabled[ingressName] = false
-				}
-			}
+// refreshApp requests a refresh of the application and waits until the controller reconciled it,
+// so the guards never judge stale diffs
+func refreshApp(ctx context.Context, appIf application.ApplicationServiceClient, appName string, hardRefresh bool) (*argoappv1.Application, error) {
+	app, err := appIf.Get(ctx, &application.ApplicationQuery{Name: &appName})
+	if err != nil {
+		return nil, err
+	}
+	reconciledAt := app.Status.ReconciledAt
+
+	app, err = appIf.Get(ctx, &application.ApplicationQuery{Name: &appName, Refresh: getRefreshType(true, hardRefresh)})
+	for err == nil && !refreshed(app, reconciledAt) {
+		log.Debugf("Waiting for the refresh of application %s", appName)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for the refresh of application %s: %v", appName, ctx.Err())
+		case <-time.After(pollInterval):
 		}
-		ingressMap[ingressName] = ingress
+		app, err = appIf.Get(ctx, &application.ApplicationQuery{Name: &appName})
 	}
+	return app, err
+}
 
-	if len(podReadinessGateEnabled) == 0 { //No ingress object,
-		log.Infof("No Ingress has annotation 'alb.ingress.kubernetes.io/target-type=ip', good to pass through")
-		return 0
+// refreshed tells whether the refresh requested after reconciledAt is done.
+// The controller removes the refresh annotation and updates reconciledAt once the application is reconciled.
+func refreshed(app *argoappv1.Application, reconciledAt metav1.Time) bool {
+	if _, requested := app.IsRefreshRequested(); requested {
+		return false
 	}
+	return app.Status.ReconciledAt.IsZero() || app.Status.ReconciledAt.After(reconciledAt.Time)
+}
 
-	// Each Ingress should have at least one PodReadinessGate points to it
-	// Resources could be Deployment or Rollout
-	for i := range resources {
-		resource := resources[i]
+// argoCDPatcher patches the live objects through the Argo CD application
+type argoCDPatcher struct {
+	appIf   application.ApplicationServiceClient
+	appName string
+}
 
-		resourceTarget, error := resource.TargetObject()
-		if error != nil || resourceTarget == nil {
-			log.Errorf("The target object has error %v", error)
-			return 200
-		}
+func (p *argoCDPatcher) Patch(ctx context.Context, resource *argoappv1.ResourceDiff, namespace string, apiVersion string, patch []byte) error {
+	_, err := p.appIf.PatchResource(ctx, &application.ApplicationResourcePatchRequest{
+		Name:         &p.appName,
+		Namespace:    namespace,
+		ResourceName: resource.Name,
+		Version:      apiVersion,
+		Group:        resource.Group,
+		Kind:         resource.Kind,
+		Patch:        string(patch),
+		PatchType:    "application/merge-patch+json",
+	})
+	return err
+}
 
-		specObj := resourceTarget.Object["spec"]
-		if specObj != nil && reflect.TypeOf(specObj).String() == "map[string]interface {}" {
-			spec := specObj.(map[string]interface{})
-
-			templateObj := spec["template"]
-			if templateObj != nil && reflect.TypeOf(templateObj).String() == "map[string]interface {}" {
-				template := templateObj.(map[string]interface{})
-
-				if template["spec"] != nil && reflect.TypeOf(template["spec"]).String() == "map[string]interface {}" {
-					templateSpec := template["spec"].(map[string]interface{})
-
-					if templateSpec["readinessGates"] != nil && reflect.TypeOf(templateSpec["readinessGates"]).String() == "[]interface {}" {
-
-						readinessGates := templateSpec["readinessGates"].([]interface{})
-
-						if len(readinessGates) > 0 {
-							for j := range readinessGates {
-								conditionObj := readinessGates[j]
-
-								var conditionType = ""
-								if reflect.TypeOf(conditionObj).String() == "map[string]interface {}" {
-									condition := conditionObj.(map[string]interface{})
-									if condition["conditionType"] != nil {
-										conditionType = condition["conditionType"].(string)
-									}
-								} else if reflect.TypeOf(conditionObj).String() == "map[string]string" {
-									condition := conditionObj.(map[string]string)
-									if condition["conditionType"] != "" {
-										conditionType = condition["conditionType"]
-									}
-								}
-
-								if strings.HasPrefix(conditionType, "target-health.alb.ingress.k8s.aws/") {
-									var l = len("target-health.alb.ingress.k8s.aws/")
-									var suffix = conditionType[l:]
-									if len(suffix) > 0 {
-										if len(suffix) > 63 { //Bug in alb-ingress-controller https://github.intuit.com/kubernetes/arktika/issues/935#issuecomment-1107371
-											// https://github.com/kubernetes-sigs/aws-alb-ingress-controller/issues/1217
-											log.Errorf("The pod readiness conditionType '%s' is more than 63 characters which a limitation from k8s, please use static conditionType 'load-balancer-tg-ready' instead", suffix)
-											os.Exit(306)
-											return 306
-										}
-										if suffix == "load-balancer-any-tg-ready" || suffix == "load-balancer-all-tg-ready" { // In this case, cd-guard will allow all Ingress passed
-											podReadinessGateEnabled["*"] = true
-										} else {
-											var array = strings.Split(suffix, "_")
-											if len(array) != 3 {
-												log.Errorf("The pod readiness condition %s doesn't have 3 parts separated with '_', the right syntax is 'INGRESS_SERVICE_PORT'", conditionType)
-												os.Exit(301)
-												return 301
-											} else {
-												ingressName := array[0]
-												if ingress, ok := ingressMap[ingressName]; ok {
-													if _, hasKey := podReadinessGateEnabled[ingressName]; hasKey {
-														//Check whether the service and port are existing.
-														if goodStatus := verifyIngressServicePort(ingress, ingressName, array[1], array[2]); goodStatus {
-															podReadinessGateEnabled[ingressName] = true
-														} else {
-															log.Errorf("The service name or port [%s:%s] deson't exist in ingress %s", array[1], array[2], ingressName)
-															os.Exit(305)
-															return 305
-														}
-													} else { //Pod Readiness Condition points to an Ingress doesn't have target-type=ip annotation
-														log.Errorf("You have a pod readiness condition, but the Ingress %s doesn't have an annotation 'alb.ingress.kubernetes.io/target-type' with value 'ip'", ingressName)
-														os.Exit(302)
-														return 302
-													}
-												} else { //Pod Readiness Condition points to a non-exists Ingress
-													log.Errorf("You have a pod readiness condition, but the Ingress %s doesn't exist", ingressName)
-													os.Exit(304)
-													return 304
-												}
-											}
-										}
-									} else {
-										log.Errorf("The pod readiness condition %s doesn't point to the right INGRESS_SERVICE_PORT", conditionType)
-										os.Exit(300)
-										return 300
-									}
-								}
-							}
-						}
-					}
-				}
-			}
+// runGuardsOnManifests hands local manifests to every guard, live objects are optional.
+// Nothing is patched in offline mode, so it always runs as dry run, but the patches could be emitted for review.
+func runGuardsOnManifests(appName string, guardsToRun []Guard, opts guardRunOptions) int {
+	targets, err := loadManifests(opts.manifests)
+	if err != nil {
+		log.Errorf("Not able to load manifests: %v", err)
+		return exitCodeGuardError
+	}
+	lives := make([]*unstructured.Unstructured, 0)
+	if opts.liveManifests != "" {
+		lives, err = loadManifests(opts.liveManifests)
+		if err != nil {
+			log.Errorf("Not able to load live manifests: %v", err)
+			return exitCodeGuardError
 		}
 	}
+	resourceDiffs, err := manifestResourceDiffs(targets, lives)
+	if err != nil {
+		log.Errorf("Not able to compare manifests: %v", err)
+		return exitCodeGuardError
+	}
 
-	for ingressName, gateEnabled := range podReadinessGateEnabled {
-		if !gateEnabled {
-			if !(podReadinessGateEnabled["*"]) { //If there is static conditionType, we don't check whether the pods belongs to Ingress, instead just let the Ingress pass through.
-				log.Errorf("Ingress '%s' with flat network, but no pod enables PodReadinessGate, please refer to this doc https://github.intuit.com/kubernetes/modern-saas-docs/blob/master/docs/developer/msaas_resiliency_iks2.md", ingressName)
-				os.Exit(500)
-				return 500
-			}
-		}
+	patcher, dryRun := opts.patcher(appName, nil)
+	app := &GuardContext{
+		Ctx:        context.Background(),
+		AppName:    appName,
+		Resources:  resourceDiffs,
+		Patcher:    patcher,
+		DryRun:     dryRun,
+		Config:     opts.config,
+		Production: opts.production || isProductionApp(appName),
+	}
+	return finishReport(opts.evaluate(app, guardsToRun), opts)
+}
+
+// runGuardsOnCluster hands the desired manifests to every guard together with their live objects in the cluster
+// of the kubeconfig context, for applications which are not deployed by Argo CD. Patches are applied to the cluster directly.
+func runGuardsOnCluster(o *GuardOptions, appName string, guardsToRun []Guard, opts guardRunOptions) int {
+	if opts.manifests == "" || opts.liveManifests != "" {
+		log.Errorf("--source %s needs --manifests, the live objects are read from the cluster", sourceKube)
+		return exitCodeGuardError
+	}
+	if err := o.complete(); err != nil {
+		log.Errorf("Not able to load kubeconfig: %v", err)
+		return exitCodeGuardError
+	}
+	log.Infof("Guarding against context '%s', cluster '%s', user '%s'", o.resultingContextName, o.resultingContext.Cluster, o.resultingContext.AuthInfo)
+
+	kube, err := o.newKubeClient()
+	if err != nil {
+		log.Error(err)
+		return exitCodeGuardError
+	}
+	namespace, _, err := o.configFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		log.Errorf("Not able to decide the namespace: %v", err)
+		return exitCodeGuardError
+	}
+	targets, err := loadManifests(opts.manifests)
+	if err != nil {
+		log.Errorf("Not able to load manifests: %v", err)
+		return exitCodeGuardError
+	}
+	lives, err := kube.liveObjects(targets, namespace)
+	if err != nil {
+		log.Errorf("Not able to read live objects: %v", err)
+		return exitCodeGuardError
+	}
+	resourceDiffs, err := manifestResourceDiffs(targets, lives)
+	if err != nil {
+		log.Errorf("Not able to compare manifests: %v", err)
+		return exitCodeGuardError
 	}
 
-	return 0
+	ctx, cancel := withTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	patcher, dryRun := opts.patcher(appName, &kubePatcher{kube: kube})
+	app := &GuardContext{
+		Ctx:        ctx,
+		AppName:    appName,
+		Resources:  resourceDiffs,
+		Patcher:    patcher,
+		DryRun:     dryRun,
+		Config:     opts.config,
+		Production: opts.production || isProductionApp(appName),
+	}
+	return finishReport(opts.evaluate(app, guardsToRun), opts)
 }
 
-func verifyIngressServicePort(ingress *unstructured.Unstructured, ingressName string, serviceName string, port string) bool {
-	specObj := ingress.Object["spec"]
-	if specObj != nil && reflect.TypeOf(specObj).String() == "map[string]interface {}" {
-		spec := specObj.(map[string]interface{})
-		// 1. Single Service Ingress https://kubernetes.io/docs/concepts/services-networking/ingress/#single-service-ingress
-		/*
-		  backend:
-		    serviceName: testsvc
-		    servicePort: 80
-		*/
-		backendObj := spec["backend"]
-		if backendObj != nil && reflect.TypeOf(backendObj).String() == "map[string]interface {}" {
-			backend := backendObj.(map[string]interface{})
-			if backend["serviceName"] != nil && backend["servicePort"] != nil {
-				return strings.EqualFold(backend["serviceName"].(string), serviceName) && string(backend["servicePort"].(int)) == port
-			}
+// evaluateGuards runs every guard, a failing guard doesn't stop the others
+func evaluateGuards(app *GuardContext, guardsToRun []Guard) *Report {
+	report := &Report{
+		App:      app.AppName,
+		Guards:   make([]GuardResult, 0),
+		Findings: make([]Finding, 0),
+	}
+	for _, guard := range guardsToRun {
+		result := GuardResult{Name: guard.Name()}
+		guardFindings, err := guard.Evaluate(app)
+		if err != nil {
+			log.Errorf("Guard '%s' failed: %v", guard.Name(), err)
+			result.Error = err.Error()
 		}
-
-		// 2. Simple fanout https://kubernetes.io/docs/concepts/services-networking/ingress/#simple-fanout
-		// spec/rules[]/http/paths[]/backend
-		rulesObj := spec["rules"]
-		if rulesObj != nil && reflect.TypeOf(rulesObj).String() == "[]interface {}" {
-			rules := rulesObj.([]interface{})
-			for _, ruleObj := range rules {
-				if reflect.TypeOf(ruleObj).String() == "map[string]interface {}" {
-					rule := ruleObj.(map[string]interface{})
-					if rule["http"] != nil && reflect.TypeOf(rule["http"]).String() == "map[string]interface {}" {
-						http := rule["http"].(map[string]interface{})
-						pathsObj := http["paths"]
-						if pathsObj != nil && reflect.TypeOf(pathsObj).String() == "[]interface {}" {
-							paths := pathsObj.([]interface{})
-							for _, pathObj := range paths {
-								if reflect.TypeOf(pathObj).String() == "map[string]interface {}" {
-									path := pathObj.(map[string]interface{})
-
-									if path["backend"] != nil && reflect.TypeOf(path["backend"]).String() == "map[string]interface {}" {
-										backend := path["backend"].(map[string]interface{})
-										if backend["serviceName"] != nil && backend["servicePort"] != nil {
-											if strings.EqualFold(backend["serviceName"].(string), serviceName) && strconv.FormatInt(backend["servicePort"].(int64), 10) == port {
-												return true
-											}
-										}
-									}
-								}
-							}
-						}
-					}
-				}
-			}
+		for i := range guardFindings {
+			guardFindings[i].Guard = guard.Name()
 		}
+		report.Guards = append(report.Guards, result)
+		findings, suppressed := suppressFindings(app, guard.Name(), app.Config.apply(app.AppName, guardFindings))
+		report.Findings = append(report.Findings, findings...)
+		report.Suppressed = append(report.Suppressed, suppressed...)
 	}
-
-	return false
+	return report
 }
 
-func ingressAndDeployment(resourceDiffs []*argoappv1.ResourceDiff) ([]*unstructured.Unstructured, map[string]*argoappv1.ResourceDiff) {
-	ingressObjects := make([]*unstructured.Unstructured, 0)
-	deploymentOrRollout := make(map[string]*argoappv1.ResourceDiff)
-
-	for i := range resourceDiffs {
-		obj := resourceDiffs[i]
-		if obj.Kind == "Ingress" {
-			targetObject, error := obj.TargetObject()
-			if error == nil && targetObject != nil {
-				copy := targetObject.DeepCopy()
-				ingressObjects = append(ingressObjects, copy)
-			}
-		} else if obj.Kind == "Deployment" {
-			deploymentOrRollout[obj.Name] = obj.DeepCopy()
-		} else if obj.Kind == "Rollout" && obj.Group == "argoproj.io" {
-			deploymentOrRollout[obj.Name] = obj.DeepCopy()
+// finishReport publishes the report and returns the exit status of the command
+func finishReport(report *Report, opts guardRunOptions) int {
+	report.failOn = opts.failOn
+	if err := publishReport(report, opts.output, opts.reportFile); err != nil {
+		log.Errorf("Not able to write the report: %v", err)
+		if report.exitCode() == exitCodeOK {
+			return exitCodeGuardError
 		}
 	}
-	return ingressObjects, deploymentOrRollout
+	return report.exitCode()
 }
@@ -1,10 +1,9 @@
 package cmd
 
 import (
-	"bou.ke/monkey"
 	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/json"
-	"os"
 	"testing"
 
 	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
@@ -15,14 +14,14 @@ const deployDiff = `{"group":"apps","kind":"Deployment","namespace":"dev-contain
 func TestApplyLastAppliedConfigPatch(t *testing.T) {
 
 	deploymentNames := make([]string, 1)
-	deploymentNames[0] = "hpa-samples-appd-deployment"
+	deploymentNames[0] = resourceKey("apps", "Deployment", "hpa-samples-appd-deployment")
 
-	deployments := make(map[string]*argoappv1.ResourceDiff)
+	deployments := make(map[string]*scaleTarget)
 
 	resourceDiff := toResourceDiff(t, deployDiff)
-	deployments["hpa-samples-appd-deployment"] = &resourceDiff
+	deployments[deploymentNames[0]] = &scaleTarget{resource: &resourceDiff, replicasPath: defaultReplicasPath}
 
-	applyLastAppliedConfigPatch(nil, nil, "dev-containers-hpa-samples-usw2-ppd-qal", deploymentNames, deployments, true)
+	applyLastAppliedConfigPatch(nil, nil, deploymentNames, deployments, true)
 
 }
 
@@ -77,8 +76,9 @@ const noHPA = `{"items":[{"kind":"Service","namespace":"dev-containers-hpa-sampl
 // No change is required
 func TestNoHpa(t *testing.T) {
 	diffs := toResourceDiffs(t, noHPA)
-	_, _, _, result := verifyHpa(diffs)
-	assert.EqualValues(t, result, 200)
+	hpas, _, _, findings := verifyHpa(diffs)
+	assert.Empty(t, hpas)
+	assert.Empty(t, findings)
 }
 
 // Enable HPA
@@ -87,11 +87,11 @@ const enableHPA = `
 
 func TestEnableHpa(t *testing.T) {
 	diffs := toResourceDiffs(t, enableHPA)
-	hpas, names, deploys, result := verifyHpa(diffs)
-	assert.EqualValues(t, result, 0)
+	hpas, names, deploys, findings := verifyHpa(diffs)
+	assert.Empty(t, findings)
 	assert.EqualValues(t, len(hpas), 1)
 
-	applyLastAppliedConfigPatch(nil, nil, "dev-containers-hpa-samples-usw2-ppd-qal", names, deploys, true)
+	applyLastAppliedConfigPatch(nil, nil, names, deploys, true)
 
 }
 
@@ -101,16 +101,16 @@ const withHPA = `
 //Test HPA and noReplicas applied
 func TestApplied(t *testing.T) {
 	diffs := toResourceDiffs(t, withHPA)
-	_, _, _, result := verifyHpa(diffs)
-	assert.EqualValues(t, result, 0)
+	_, _, _, findings := verifyHpa(diffs)
+	assert.Empty(t, findings)
 }
 
 const withDeploymentChange = `{"items":[{"kind":"Service","namespace":"dev-containers-hpa-samples-usw2-ppd-qal","name":"hpa-samples-appd-service","targetState":"{\"apiVersion\":\"v1\",\"kind\":\"Service\",\"metadata\":{\"labels\":{\"applications.argoproj.io/app-name\":\"dev-containers-hpa-samples-usw2-ppd-qal\",\"iks-metric\":\"actuator-prometheus\"},\"name\":\"hpa-samples-appd-service\",\"namespace\":\"dev-containers-hpa-samples-usw2-ppd-qal\"},\"spec\":{\"ports\":[{\"name\":\"service\",\"port\":443,\"targetPort\":8443},{\"name\":\"iks-metric\",\"port\":8490,\"targetPort\":8490}],\"selector\":{\"app\":\"hpa-samples\"},\"type\":\"NodePort\"}}","liveState":"{\"apiVersion\":\"v1\",\"kind\":\"Service\",\"metadata\":{\"annotations\":{\"kubectl.kubernetes.io/last-applied-configuration\":\"{\\\"apiVersion\\\":\\\"v1\\\",\\\"kind\\\":\\\"Service\\\",\\\"metadata\\\":{\\\"annotations\\\":{},\\\"labels\\\":{\\\"applications.argoproj.io/app-name\\\":\\\"dev-containers-hpa-samples-usw2-ppd-qal\\\",\\\"iks-metric\\\":\\\"actuator-prometheus\\\"},\\\"name\\\":\\\"hpa-samples-appd-service\\\",\\\"namespace\\\":\\\"dev-containers-hpa-samples-usw2-ppd-qal\\\"},\\\"spec\\\":{\\\"ports\\\":[{\\\"name\\\":\\\"service\\\",\\\"port\\\":443,\\\"targetPort\\\":8443},{\\\"name\\\":\\\"iks-metric\\\",\\\"port\\\":8490,\\\"targetPort\\\":8490}],\\\"selector\\\":{\\\"app\\\":\\\"hpa-samples\\\"},\\\"type\\\":\\\"NodePort\\\"}}\\n\"},\"creationTimestamp\":\"2019-05-23T22:03:45Z\",\"labels\":{\"applications.argoproj.io/app-name\":\"dev-containers-hpa-samples-usw2-ppd-qal\",\"iks-metric\":\"actuator-prometheus\"},\"name\":\"hpa-samples-appd-service\",\"namespace\":\"dev-containers-hpa-samples-usw2-ppd-qal\",\"resourceVersion\":\"249265864\",\"selfLink\":\"/api/v1/namespaces/dev-containers-hpa-samples-usw2-ppd-qal/services/hpa-samples-appd-service\",\"uid\":\"a23d282c-7da6-11e9-ad6f-0a4b8fec9f72\"},\"spec\":{\"clusterIP\":\"100.69.146.135\",\"externalTrafficPolicy\":\"Cluster\",\"ports\":[{\"name\":\"service\",\"nodePort\":31309,\"port\":443,\"protocol\":\"TCP\",\"targetPort\":8443},{\"name\":\"iks-metric\",\"nodePort\":30832,\"port\":8490,\"protocol\":\"TCP\",\"targetPort\":8490}],\"selector\":{\"app\":\"hpa-samples\"},\"sessionAffinity\":\"None\",\"type\":\"NodePort\"},\"status\":{\"loadBalancer\":{}}}"},{"group":"apps","kind":"Deployment","namespace":"dev-containers-hpa-samples-usw2-ppd-qal","name":"hpa-samples-appd-deployment","targetState":"{\"apiVersion\":\"apps/v1beta2\",\"kind\":\"Deployment\",\"metadata\":{\"labels\":{\"app\":\"hpa-samples\",\"appType\":\"spring-boot\",\"applications.argoproj.io/app-name\":\"dev-containers-hpa-samples-usw2-ppd-qal\",\"assetId\":\"8215709212542571317\",\"buildType\":\"maven\",\"env\":\"qal\",\"l1\":\"dev\",\"l2\":\"containers\"},\"name\":\"hpa-samples-appd-deployment\",\"namespace\":\"dev-containers-hpa-samples-usw2-ppd-qal\"},\"spec\":{\"selector\":{\"matchLabels\":{\"app\":\"hpa-samples\"}},\"template\":{\"metadata\":{\"annotations\":{\"iam.amazonaws.com/role\":\"k8s-dev-containers-hpa-samples-usw2-ppd-qal\",\"prometheus.io/path\":\"/actuator/prometheus\",\"prometheus.io/port\":\"8490\",\"prometheus.io/scheme\":\"https\",\"prometheus.io/scrape\":\"true\"},\"labels\":{\"app\":\"hpa-samples\",\"applications.argoproj.io/app-name\":\"dev-containers-hpa-samples-usw2-ppd-qal\",\"assetId\":\"8215709212542571317\",\"env\":\"qal\",\"l1\":\"dev\",\"l2\":\"containers\"}},\"spec\":{\"containers\":[{\"env\":[{\"name\":\"APP_NAME\",\"value\":\"hpa-samples\"},{\"name\":\"APP_ENV\",\"value\":\"qal\"},{\"name\":\"ASSET_ID\",\"value\":\"8215709212542571317\"},{\"name\":\"L1\",\"value\":\"dev\"},{\"name\":\"L2\",\"value\":\"containers\"},{\"name\":\"APPDYNAMICS_AGENT_TIER_NAME\",\"value\":\"app\"},{\"name\":\"APPDYNAMICS_CONTROLLER_HOST_NAME\",\"value\":\"intuit-ss-dev.saas.appdynamics.com\"},{\"name\":\"APPDYNAMICS_AGENT_ACCOUNT_NAME\",\"value\":\"intuit-ss-dev\"},{\"name\":\"test\",\"value\":\"123\"}],\"image\":\"docker.artifactory.a.intuit.com/dev/containers/hpa-samples/service/hpa-samples:jenkins-dev-containers-hpa-samples-hpa-samples-master-42-ed343db\",\"livenessProbe\":{\"failureThreshold\":5,\"httpGet\":{\"path\":\"/health/full\",\"port\":8443,\"scheme\":\"HTTPS\"},\"initialDelaySeconds\":90,\"periodSeconds\":5,\"successThreshold\":1,\"timeoutSeconds\":1},\"name\":\"app\",\"ports\":[{\"containerPort\":8443,\"name\":\"service\"},{\"containerPort\":8490,\"name\":\"metrics\"}],\"readinessProbe\":{\"failureThreshold\":3,\"httpGet\":{\"path\":\"/health/full\",\"port\":8443,\"scheme\":\"HTTPS\"},\"initialDelaySeconds\":75,\"periodSeconds\":5,\"successThreshold\":3,\"timeoutSeconds\":1},\"resources\":{\"limits\":{\"cpu\":\"1\",\"memory\":\"4096M\"},\"requests\":{\"cpu\":\"1\",\"memory\":\"4096M\"}},\"volumeMounts\":[{\"mountPath\":\"/etc/secrets\",\"name\":\"secrets\"}]}],\"initContainers\":[{\"args\":[\"-c\",\"/usr/local/bin/segment-app-init secrets get\"],\"command\":[\"/bin/sh\"],\"env\":[{\"name\":\"APP_NAME\",\"value\":\"hpa-samples\"},{\"name\":\"APP_ENV\",\"value\":\"qal\"},{\"name\":\"ASSET_ID\",\"value\":\"8215709212542571317\"},{\"name\":\"APPDYNAMICS_AGENT_ACCOUNT_NAME\",\"value\":\"intuit-ss-dev\"},{\"name\":\"SEGMENT_CLUSTER_ROLE_ARN\",\"value\":\"arn:aws:iam::490747939488:role/shared.paas-preprod-west2.cluster.k8s.local\"},{\"name\":\"SEGMENT_IDPS_APPLIANCE\",\"value\":\"Paask8s-PRODUCTION-V8NVUY.pd.idps.a.intuit.com\"},{\"name\":\"SEGMENT_IDPS_POLICY_ID\",\"value\":\"p-7r5ghd8djt0b\"}],\"image\":\"docker.artifactory.a.intuit.com/dev/containers/segment-app-init/service/segment-app-init:master-32-5319a9b\",\"name\":\"segment-app-init\",\"volumeMounts\":[{\"mountPath\":\"/etc/secrets\",\"name\":\"secrets\"}]}],\"volumes\":[{\"emptyDir\":{\"medium\":\"Memory\"},\"name\":\"secrets\"}]}}}}","liveState":"{\"apiVersion\":\"apps/v1beta2\",\"kind\":\"Deployment\",\"metadata\":{\"annotations\":{\"deployment.kubernetes.io/revision\":\"6\",\"kubectl.kubernetes.io/last-applied-configuration\":\"{\\\"apiVersion\\\":\\\"apps/v1beta2\\\",\\\"kind\\\":\\\"Deployment\\\",\\\"metadata\\\":{\\\"annotations\\\":{},\\\"labels\\\":{\\\"app\\\":\\\"hpa-samples\\\",\\\"appType\\\":\\\"spring-boot\\\",\\\"applications.argoproj.io/app-name\\\":\\\"dev-containers-hpa-samples-usw2-ppd-qal\\\",\\\"assetId\\\":\\\"8215709212542571317\\\",\\\"buildType\\\":\\\"maven\\\",\\\"env\\\":\\\"qal\\\",\\\"l1\\\":\\\"dev\\\",\\\"l2\\\":\\\"containers\\\"},\\\"name\\\":\\\"hpa-samples-appd-deployment\\\",\\\"namespace\\\":\\\"dev-containers-hpa-samples-usw2-ppd-qal\\\"},\\\"spec\\\":{\\\"selector\\\":{\\\"matchLabels\\\":{\\\"app\\\":\\\"hpa-samples\\\"}},\\\"template\\\":{\\\"metadata\\\":{\\\"annotations\\\":{\\\"iam.amazonaws.com/role\\\":\\\"k8s-dev-containers-hpa-samples-usw2-ppd-qal\\\",\\\"prometheus.io/path\\\":\\\"/actuator/prometheus\\\",\\\"prometheus.io/port\\\":\\\"8490\\\",\\\"prometheus.io/scheme\\\":\\\"https\\\",\\\"prometheus.io/scrape\\\":\\\"true\\\"},\\\"labels\\\":{\\\"app\\\":\\\"hpa-samples\\\",\\\"applications.argoproj.io/app-name\\\":\\\"dev-containers-hpa-samples-usw2-ppd-qal\\\",\\\"assetId\\\":\\\"8215709212542571317\\\",\\\"env\\\":\\\"qal\\\",\\\"l1\\\":\\\"dev\\\",\\\"l2\\\":\\\"containers\\\"}},\\\"spec\\\":{\\\"containers\\\":[{\\\"env\\\":[{\\\"name\\\":\\\"APP_NAME\\\",\\\"value\\\":\\\"hpa-samples\\\"},{\\\"name\\\":\\\"APP_ENV\\\",\\\"value\\\":\\\"qal\\\"},{\\\"name\\\":\\\"ASSET_ID\\\",\\\"value\\\":\\\"8215709212542571317\\\"},{\\\"name\\\":\\\"L1\\\",\\\"value\\\":\\\"dev\\\"},{\\\"name\\\":\\\"L2\\\",\\\"value\\\":\\\"containers\\\"},{\\\"name\\\":\\\"APPDYNAMICS_AGENT_TIER_NAME\\\",\\\"value\\\":\\\"app\\\"},{\\\"name\\\":\\\"APPDYNAMICS_CONTROLLER_HOST_NAME\\\",\\\"value\\\":\\\"intuit-ss-dev.saas.appdynamics.com\\\"},{\\\"name\\\":\\\"APPDYNAMICS_AGENT_ACCOUNT_NAME\\\",\\\"value\\\":\\\"intuit-ss-dev\\\"},{\\\"name\\\":\\\"test\\\",\\\"value\\\":\\\"123\\\"}],\\\"image\\\":\\\"docker.artifactory.a.intuit.com/dev/containers/hpa-samples/service/hpa-samples:jenkins-dev-containers-hpa-samples-hpa-samples-master-42-ed343db\\\",\\\"livenessProbe\\\":{\\\"failureThreshold\\\":5,\\\"httpGet\\\":{\\\"path\\\":\\\"/health/full\\\",\\\"port\\\":8443,\\\"scheme\\\":\\\"HTTPS\\\"},\\\"initialDelaySeconds\\\":90,\\\"periodSeconds\\\":5,\\\"successThreshold\\\":1,\\\"timeoutSeconds\\\":1},\\\"name\\\":\\\"app\\\",\\\"ports\\\":[{\\\"containerPort\\\":8443,\\\"name\\\":\\\"service\\\"},{\\\"containerPort\\\":8490,\\\"name\\\":\\\"metrics\\\"}],\\\"readinessProbe\\\":{\\\"failureThreshold\\\":3,\\\"httpGet\\\":{\\\"path\\\":\\\"/health/full\\\",\\\"port\\\":8443,\\\"scheme\\\":\\\"HTTPS\\\"},\\\"initialDelaySeconds\\\":75,\\\"periodSeconds\\\":5,\\\"successThreshold\\\":3,\\\"timeoutSeconds\\\":1},\\\"resources\\\":{\\\"limits\\\":{\\\"cpu\\\":\\\"1\\\",\\\"memory\\\":\\\"4096M\\\"},\\\"requests\\\":{\\\"cpu\\\":\\\"1\\\",\\\"memory\\\":\\\"4096M\\\"}},\\\"volumeMounts\\\":[{\\\"mountPath\\\":\\\"/etc/secrets\\\",\\\"name\\\":\\\"secrets\\\"}]}],\\\"initContainers\\\":[{\\\"args\\\":[\\\"-c\\\",\\\"/usr/local/bin/segment-app-init secrets get\\\"],\\\"command\\\":[\\\"/bin/sh\\\"],\\\"env\\\":[{\\\"name\\\":\\\"APP_NAME\\\",\\\"value\\\":\\\"hpa-samples\\\"},{\\\"name\\\":\\\"APP_ENV\\\",\\\"value\\\":\\\"qal\\\"},{\\\"name\\\":\\\"ASSET_ID\\\",\\\"value\\\":\\\"8215709212542571317\\\"},{\\\"name\\\":\\\"APPDYNAMICS_AGENT_ACCOUNT_NAME\\\",\\\"value\\\":\\\"intuit-ss-dev\\\"},{\\\"name\\\":\\\"SEGMENT_CLUSTER_ROLE_ARN\\\",\\\"value\\\":\\\"arn:aws:iam::490747939488:role/shared.paas-preprod-west2.cluster.k8s.local\\\"},{\\\"name\\\":\\\"SEGMENT_IDPS_APPLIANCE\\\",\\\"value\\\":\\\"Paask8s-PRODUCTION-V8NVUY.pd.idps.a.intuit.com\\\"},{\\\"name\\\":\\\"SEGMENT_IDPS_POLICY_ID\\\",\\\"value\\\":\\\"p-7r5ghd8djt0b\\\"}],\\\"image\\\":\\\"docker.artifactory.a.intuit.com/dev/containers/segment-app-init/service/segment-app-init:master-32-5319a9b\\\",\\\"name\\\":\\\"segment-app-init\\\",\\\"volumeMounts\\\":[{\\\"mountPath\\\":\\\"/etc/secrets\\\",\\\"name\\\":\\\"secrets\\\"}]}],\\\"volumes\\\":[{\\\"emptyDir\\\":{\\\"medium\\\":\\\"Memory\\\"},\\\"name\\\":\\\"secrets\\\"}]}}}}\\n\"},\"creationTimestamp\":\"2019-05-23T22:03:45Z\",\"generation\":54,\"labels\":{\"app\":\"hpa-samples\",\"appType\":\"spring-boot\",\"applications.argoproj.io/app-name\":\"dev-containers-hpa-samples-usw2-ppd-qal\",\"assetId\":\"8215709212542571317\",\"buildType\":\"maven\",\"env\":\"qal\",\"l1\":\"dev\",\"l2\":\"containers\"},\"name\":\"hpa-samples-appd-deployment\",\"namespace\":\"dev-containers-hpa-samples-usw2-ppd-qal\",\"resourceVersion\":\"258587312\",\"selfLink\":\"/apis/apps/v1/namespaces/dev-containers-hpa-samples-usw2-ppd-qal/deployments/hpa-samples-appd-deployment\",\"uid\":\"a25c47e1-7da6-11e9-81a8-06f75ee834e4\"},\"spec\":{\"progressDeadlineSeconds\":600,\"replicas\":2,\"revisionHistoryLimit\":10,\"selector\":{\"matchLabels\":{\"app\":\"hpa-samples\"}},\"strategy\":{\"rollingUpdate\":{\"maxSurge\":\"25%\",\"maxUnavailable\":\"25%\"},\"type\":\"RollingUpdate\"},\"template\":{\"metadata\":{\"annotations\":{\"iam.amazonaws.com/role\":\"k8s-dev-containers-hpa-samples-usw2-ppd-qal\",\"prometheus.io/path\":\"/actuator/prometheus\",\"prometheus.io/port\":\"8490\",\"prometheus.io/scheme\":\"https\",\"prometheus.io/scrape\":\"true\"},\"creationTimestamp\":null,\"labels\":{\"app\":\"hpa-samples\",\"applications.argoproj.io/app-name\":\"dev-containers-hpa-samples-usw2-ppd-qal\",\"assetId\":\"8215709212542571317\",\"env\":\"qal\",\"l1\":\"dev\",\"l2\":\"containers\"}},\"spec\":{\"containers\":[{\"env\":[{\"name\":\"APP_NAME\",\"value\":\"hpa-samples\"},{\"name\":\"APP_ENV\",\"value\":\"qal\"},{\"name\":\"ASSET_ID\",\"value\":\"8215709212542571317\"},{\"name\":\"L1\",\"value\":\"dev\"},{\"name\":\"L2\",\"value\":\"containers\"},{\"name\":\"APPDYNAMICS_AGENT_TIER_NAME\",\"value\":\"app\"},{\"name\":\"APPDYNAMICS_CONTROLLER_HOST_NAME\",\"value\":\"intuit-ss-dev.saas.appdynamics.com\"},{\"name\":\"APPDYNAMICS_AGENT_ACCOUNT_NAME\",\"value\":\"intuit-ss-dev\"},{\"name\":\"test\",\"value\":\"123\"}],\"image\":\"docker.artifactory.a.intuit.com/dev/containers/hpa-samples/service/hpa-samples:jenkins-dev-containers-hpa-samples-hpa-samples-master-42-ed343db\",\"imagePullPolicy\":\"IfNotPresent\",\"livenessProbe\":{\"failureThreshold\":5,\"httpGet\":{\"path\":\"/health/full\",\"port\":8443,\"scheme\":\"HTTPS\"},\"initialDelaySeconds\":90,\"periodSeconds\":5,\"successThreshold\":1,\"timeoutSeconds\":1},\"name\":\"app\",\"ports\":[{\"containerPort\":8443,\"name\":\"service\",\"protocol\":\"TCP\"},{\"containerPort\":8490,\"name\":\"metrics\",\"protocol\":\"TCP\"}],\"readinessProbe\":{\"failureThreshold\":3,\"httpGet\":{\"path\":\"/health/full\",\"port\":8443,\"scheme\":\"HTTPS\"},\"initialDelaySeconds\":75,\"periodSeconds\":5,\"successThreshold\":3,\"timeoutSeconds\":1},\"resources\":{\"limits\":{\"cpu\":\"1\",\"memory\":\"4096M\"},\"requests\":{\"cpu\":\"1\",\"memory\":\"4096M\"}},\"terminationMessagePath\":\"/dev/termination-log\",\"terminationMessagePolicy\":\"File\",\"volumeMounts\":[{\"mountPath\":\"/etc/secrets\",\"name\":\"secrets\"}]}],\"dnsPolicy\":\"ClusterFirst\",\"initContainers\":[{\"args\":[\"-c\",\"/usr/local/bin/segment-app-init secrets get\"],\"command\":[\"/bin/sh\"],\"env\":[{\"name\":\"APP_NAME\",\"value\":\"hpa-samples\"},{\"name\":\"APP_ENV\",\"value\":\"qal\"},{\"name\":\"ASSET_ID\",\"value\":\"8215709212542571317\"},{\"name\":\"APPDYNAMICS_AGENT_ACCOUNT_NAME\",\"value\":\"intuit-ss-dev\"},{\"name\":\"SEGMENT_CLUSTER_ROLE_ARN\",\"value\":\"arn:aws:iam::490747939488:role/shared.paas-preprod-west2.cluster.k8s.local\"},{\"name\":\"SEGMENT_IDPS_APPLIANCE\",\"value\":\"Paask8s-PRODUCTION-V8NVUY.pd.idps.a.intuit.com\"},{\"name\":\"SEGMENT_IDPS_POLICY_ID\",\"value\":\"p-7r5ghd8djt0b\"}],\"image\":\"docker.artifactory.a.intuit.com/dev/containers/segment-app-init/service/segment-app-init:master-32-5319a9b\",\"imagePullPolicy\":\"IfNotPresent\",\"name\":\"segment-app-init\",\"resources\":{},\"terminationMessagePath\":\"/dev/termination-log\",\"terminationMessagePolicy\":\"File\",\"volumeMounts\":[{\"mountPath\":\"/etc/secrets\",\"name\":\"secrets\"}]}],\"restartPolicy\":\"Always\",\"schedulerName\":\"default-scheduler\",\"securityContext\":{},\"terminationGracePeriodSeconds\":30,\"volumes\":[{\"emptyDir\":{\"medium\":\"Memory\"},\"name\":\"secrets\"}]}}},\"status\":{\"availableReplicas\":2,\"conditions\":[{\"lastTransitionTime\":\"2019-06-03T21:31:08Z\",\"lastUpdateTime\":\"2019-06-03T21:31:08Z\",\"message\":\"Deployment has minimum availability.\",\"reason\":\"MinimumReplicasAvailable\",\"status\":\"True\",\"type\":\"Available\"},{\"lastTransitionTime\":\"2019-05-23T22:03:45Z\",\"lastUpdateTime\":\"2019-06-03T22:47:42Z\",\"message\":\"ReplicaSet \\\"hpa-samples-appd-deployment-697bc56d77\\\" is progressing.\",\"reason\":\"ReplicaSetUpdated\",\"status\":\"True\",\"type\":\"Progressing\"}],\"observedGeneration\":54,\"readyReplicas\":2,\"replicas\":3,\"unavailableReplicas\":1,\"updatedReplicas\":1}}"},{"group":"autoscaling","kind":"HorizontalPodAutoscaler","namespace":"dev-containers-hpa-samples-usw2-ppd-qal","name":"hpa-samples-hpa","targetState":"{\"apiVersion\":\"autoscaling/v2beta1\",\"kind\":\"HorizontalPodAutoscaler\",\"metadata\":{\"labels\":{\"app\":\"hpa-samples\",\"applications.argoproj.io/app-name\":\"dev-containers-hpa-samples-usw2-ppd-qal\"},\"name\":\"hpa-samples-hpa\",\"namespace\":\"dev-containers-hpa-samples-usw2-ppd-qal\"},\"spec\":{\"maxReplicas\":10,\"metrics\":[{\"object\":{\"metricName\":\"namespace_app_pod_cpu_utilization\",\"target\":{\"apiVersion\":\"apps/v1beta2\",\"kind\":\"Deployment\",\"name\":\"hpa-samples\"},\"targetValue\":\"80\"},\"type\":\"Object\"}],\"minReplicas\":2,\"scaleTargetRef\":{\"apiVersion\":\"apps/v1beta2\",\"kind\":\"Deployment\",\"name\":\"hpa-samples-appd-deployment\"}}}","liveState":"{\"apiVersion\":\"autoscaling/v2beta1\",\"kind\":\"HorizontalPodAutoscaler\",\"metadata\":{\"annotations\":{\"kubectl.kubernetes.io/last-applied-configuration\":\"{\\\"apiVersion\\\":\\\"autoscaling/v2beta1\\\",\\\"kind\\\":\\\"HorizontalPodAutoscaler\\\",\\\"metadata\\\":{\\\"annotations\\\":{},\\\"labels\\\":{\\\"app\\\":\\\"hpa-samples\\\",\\\"applications.argoproj.io/app-name\\\":\\\"dev-containers-hpa-samples-usw2-ppd-qal\\\"},\\\"name\\\":\\\"hpa-samples-hpa\\\",\\\"namespace\\\":\\\"dev-containers-hpa-samples-usw2-ppd-qal\\\"},\\\"spec\\\":{\\\"maxReplicas\\\":10,\\\"metrics\\\":[{\\\"object\\\":{\\\"metricName\\\":\\\"namespace_app_pod_cpu_utilization\\\",\\\"target\\\":{\\\"apiVersion\\\":\\\"apps/v1beta2\\\",\\\"kind\\\":\\\"Deployment\\\",\\\"name\\\":\\\"hpa-samples\\\"},\\\"targetValue\\\":\\\"80\\\"},\\\"type\\\":\\\"Object\\\"}],\\\"minReplicas\\\":2,\\\"scaleTargetRef\\\":{\\\"apiVersion\\\":\\\"apps/v1beta2\\\",\\\"kind\\\":\\\"Deployment\\\",\\\"name\\\":\\\"hpa-samples-appd-deployment\\\"}}}\\n\"},\"creationTimestamp\":\"2019-06-03T22:37:25Z\",\"labels\":{\"app\":\"hpa-samples\",\"applications.argoproj.io/app-name\":\"dev-containers-hpa-samples-usw2-ppd-qal\"},\"name\":\"hpa-samples-hpa\",\"namespace\":\"dev-containers-hpa-samples-usw2-ppd-qal\",\"resourceVersion\":\"258587716\",\"selfLink\":\"/apis/autoscaling/v1/namespaces/dev-containers-hpa-samples-usw2-ppd-qal/horizontalpodautoscalers/hpa-samples-hpa\",\"uid\":\"28ba3570-8650-11e9-9918-02aee870c79e\"},\"spec\":{\"maxReplicas\":10,\"metrics\":[{\"object\":{\"metricName\":\"namespace_app_pod_cpu_utilization\",\"target\":{\"apiVersion\":\"apps/v1beta2\",\"kind\":\"Deployment\",\"name\":\"hpa-samples\"},\"targetValue\":\"80\"},\"type\":\"Object\"}],\"minReplicas\":2,\"scaleTargetRef\":{\"apiVersion\":\"apps/v1beta2\",\"kind\":\"Deployment\",\"name\":\"hpa-samples-appd-deployment\"}},\"status\":{\"conditions\":[{\"lastTransitionTime\":\"2019-06-03T22:48:25Z\",\"message\":\"the time since the previous scale is still within both the downscale and upscale forbidden windows\",\"reason\":\"BackoffBoth\",\"status\":\"False\",\"type\":\"AbleToScale\"},{\"lastTransitionTime\":\"2019-06-03T22:37:55Z\",\"message\":\"the HPA was able to successfully calculate a replica count from Deployment metric namespace_app_pod_cpu_utilization\",\"reason\":\"ValidMetricFound\",\"status\":\"True\",\"type\":\"ScalingActive\"},{\"lastTransitionTime\":\"2019-06-03T22:37:55Z\",\"message\":\"the desired replica count is increasing faster than the maximum scale rate\",\"reason\":\"TooFewReplicas\",\"status\":\"True\",\"type\":\"ScalingLimited\"}],\"currentMetrics\":[{\"object\":{\"currentValue\":\"4987m\",\"metricName\":\"namespace_app_pod_cpu_utilization\",\"target\":{\"apiVersion\":\"apps/v1beta2\",\"kind\":\"Deployment\",\"name\":\"hpa-samples\"}},\"type\":\"Object\"}],\"currentReplicas\":3,\"desiredReplicas\":3,\"lastScaleTime\":\"2019-06-03T22:47:55Z\"}}"},{"group":"extensions","kind":"Ingress","namespace":"dev-containers-hpa-samples-usw2-ppd-qal","name":"hpa-samples-appd-ingress","targetState":"{\"apiVersion\":\"extensions/v1beta1\",\"kind\":\"Ingress\",\"metadata\":{\"annotations\":{\"alb.ingress.kubernetes.io/backend-protocol\":\"HTTPS\",\"alb.ingress.kubernetes.io/certificate-arn\":\"arn:aws:acm:us-west-2:490747939488:certificate/c60e4e8c-5ea2-46ff-a9a0-248436fc06a5\",\"alb.ingress.kubernetes.io/healthcheck-path\":\"/health/full\",\"alb.ingress.kubernetes.io/healthcheck-protocol\":\"HTTPS\",\"alb.ingress.kubernetes.io/listen-ports\":\"[{\\\"HTTPS\\\": 443}]\",\"alb.ingress.kubernetes.io/load-balancer-attributes\":\"access_logs.s3.enabled=false\",\"alb.ingress.kubernetes.io/scheme\":\"internet-facing\",\"alb.ingress.kubernetes.io/security-groups\":\"iks-intuit-cidr-ingress-tcp-443, iks-intuit-api-gw-ingress-preprod-tcp-443, iks-intuit-app-alb-custom-ingress, iks-intuit-ibp-ingress-tcp-443\",\"alb.ingress.kubernetes.io/ssl-policy\":\"ELBSecurityPolicy-TLS-1-2-2017-01\",\"alb.ingress.kubernetes.io/subnets\":\"IngressSubnetAz1, IngressSubnetAz2, IngressSubnetAz3\",\"external-dns.alpha.kubernetes.io/hostname\":\"dev-containers-qal-hpa-samples.paas-preprod-west.a.intuit.com\",\"kubernetes.io/ingress.class\":\"aws-alb\"},\"labels\":{\"app\":\"hpa-samples\",\"applications.argoproj.io/app-name\":\"dev-containers-hpa-samples-usw2-ppd-qal\"},\"name\":\"hpa-samples-appd-ingress\",\"namespace\":\"dev-containers-hpa-samples-usw2-ppd-qal\"},\"spec\":{\"rules\":[{\"http\":{\"paths\":[{\"backend\":{\"serviceName\":\"hpa-samples-appd-service\",\"servicePort\":443},\"path\":\"/*\"}]}}]}}","liveState":"{\"apiVersion\":\"extensions/v1beta1\",\"kind\":\"Ingress\",\"metadata\":{\"annotations\":{\"alb.ingress.kubernetes.io/backend-protocol\":\"HTTPS\",\"alb.ingress.kubernetes.io/certificate-arn\":\"arn:aws:acm:us-west-2:490747939488:certificate/c60e4e8c-5ea2-46ff-a9a0-248436fc06a5\",\"alb.ingress.kubernetes.io/healthcheck-path\":\"/health/full\",\"alb.ingress.kubernetes.io/healthcheck-protocol\":\"HTTPS\",\"alb.ingress.kubernetes.io/listen-ports\":\"[{\\\"HTTPS\\\": 443}]\",\"alb.ingress.kubernetes.io/load-balancer-attributes\":\"access_logs.s3.enabled=false\",\"alb.ingress.kubernetes.io/scheme\":\"internet-facing\",\"alb.ingress.kubernetes.io/security-groups\":\"iks-intuit-cidr-ingress-tcp-443, iks-intuit-api-gw-ingress-preprod-tcp-443, iks-intuit-app-alb-custom-ingress, iks-intuit-ibp-ingress-tcp-443\",\"alb.ingress.kubernetes.io/ssl-policy\":\"ELBSecurityPolicy-TLS-1-2-2017-01\",\"alb.ingress.kubernetes.io/subnets\":\"IngressSubnetAz1, IngressSubnetAz2, IngressSubnetAz3\",\"external-dns.alpha.kubernetes.io/hostname\":\"dev-containers-qal-hpa-samples.paas-preprod-west.a.intuit.com\",\"kubectl.kubernetes.io/last-applied-configuration\":\"{\\\"apiVersion\\\":\\\"extensions/v1beta1\\\",\\\"kind\\\":\\\"Ingress\\\",\\\"metadata\\\":{\\\"annotations\\\":{\\\"alb.ingress.kubernetes.io/backend-protocol\\\":\\\"HTTPS\\\",\\\"alb.ingress.kubernetes.io/certificate-arn\\\":\\\"arn:aws:acm:us-west-2:490747939488:certificate/c60e4e8c-5ea2-46ff-a9a0-248436fc06a5\\\",\\\"alb.ingress.kubernetes.io/healthcheck-path\\\":\\\"/health/full\\\",\\\"alb.ingress.kubernetes.io/healthcheck-protocol\\\":\\\"HTTPS\\\",\\\"alb.ingress.kubernetes.io/listen-ports\\\":\\\"[{\\\\\\\"HTTPS\\\\\\\": 443}]\\\",\\\"alb.ingress.kubernetes.io/load-balancer-attributes\\\":\\\"access_logs.s3.enabled=false\\\",\\\"alb.ingress.kubernetes.io/scheme\\\":\\\"internet-facing\\\",\\\"alb.ingress.kubernetes.io/security-groups\\\":\\\"iks-intuit-cidr-ingress-tcp-443, iks-intuit-api-gw-ingress-preprod-tcp-443, iks-intuit-app-alb-custom-ingress, iks-intuit-ibp-ingress-tcp-443\\\",\\\"alb.ingress.kubernetes.io/ssl-policy\\\":\\\"ELBSecurityPolicy-TLS-1-2-2017-01\\\",\\\"alb.ingress.kubernetes.io/subnets\\\":\\\"IngressSubnetAz1, IngressSubnetAz2, IngressSubnetAz3\\\",\\\"external-dns.alpha.kubernetes.io/hostname\\\":\\\"dev-containers-qal-hpa-samples.paas-preprod-west.a.intuit.com\\\",\\\"kubernetes.io/ingress.class\\\":\\\"aws-alb\\\"},\\\"labels\\\":{\\\"app\\\":\\\"hpa-samples\\\",\\\"applications.argoproj.io/app-name\\\":\\\"dev-containers-hpa-samples-usw2-ppd-qal\\\"},\\\"name\\\":\\\"hpa-samples-appd-ingress\\\",\\\"namespace\\\":\\\"dev-containers-hpa-samples-usw2-ppd-qal\\\"},\\\"spec\\\":{\\\"rules\\\":[{\\\"http\\\":{\\\"paths\\\":[{\\\"backend\\\":{\\\"serviceName\\\":\\\"hpa-samples-appd-service\\\",\\\"servicePort\\\":443},\\\"path\\\":\\\"/*\\\"}]}}]}}\\n\",\"kubernetes.io/ingress.class\":\"aws-alb\"},\"creationTimestamp\":\"2019-06-02T07:49:20Z\",\"generation\":2,\"labels\":{\"app\":\"hpa-samples\",\"applications.argoproj.io/app-name\":\"dev-containers-hpa-samples-usw2-ppd-qal\"},\"name\":\"hpa-samples-appd-ingress\",\"namespace\":\"dev-containers-hpa-samples-usw2-ppd-qal\",\"resourceVersion\":\"257218198\",\"selfLink\":\"/apis/extensions/v1beta1/namespaces/dev-containers-hpa-samples-usw2-ppd-qal/ingresses/hpa-samples-appd-ingress\",\"uid\":\"ee715459-850a-11e9-81a8-06f75ee834e4\"},\"spec\":{\"rules\":[{\"http\":{\"paths\":[{\"backend\":{\"serviceName\":\"hpa-samples-appd-service\",\"servicePort\":443},\"path\":\"/*\"}]}}]},\"status\":{\"loadBalancer\":{\"ingress\":[{\"hostname\":\"paaspreprod-devcontainersh-bec3-1650558956.us-west-2.elb.amazonaws.com\"}]}}}"}]}`
 
 func TestDeploymentChange(t *testing.T) {
 	diffs := toResourceDiffs(t, withDeploymentChange)
-	_, _, _, result := verifyHpa(diffs)
-	assert.EqualValues(t, result, 0)
+	_, _, _, findings := verifyHpa(diffs)
+	assert.Empty(t, findings)
 }
 
 const withDeploymentChangeName = `
@@ -152,18 +152,13 @@ const withDeploymentChangeName = `
 `
 
 func TestDeploymentChangeName(t *testing.T) {
-	fakeExit := func(int) {
-		panic(301)
-	}
-	patch := monkey.Patch(os.Exit, fakeExit)
-	defer patch.Unpatch()
-
-	f := func() {
-		diffs := toResourceDiffs(t, withDeploymentChangeName)
-		verifyHpa(diffs)
-	}
-
-	assert.PanicsWithValue(t, 301, f)
+	diffs := toResourceDiffs(t, withDeploymentChangeName)
+	_, _, _, findings := verifyHpa(diffs)
+	assert.EqualValues(t, len(findings), 1)
+	assert.EqualValues(t, findings[0].Rule, "hpa-target-missing")
+	assert.EqualValues(t, findings[0].Kind, "HorizontalPodAutoscaler")
+	assert.EqualValues(t, findings[0].Name, "hpa-samples-hpa")
+	assert.True(t, hasErrors(findings))
 }
 
 const rolloutWithNoReplicas = `
@@ -172,10 +167,10 @@ const rolloutWithNoReplicas = `
 //Test HPA and noReplicas applied
 func TestRolloutWithNoReplicas(t *testing.T) {
 	diffs := toResourceDiffs(t, rolloutWithNoReplicas)
-	_, names, deploys, result := verifyHpa(diffs)
-	assert.EqualValues(t, result, 0)
+	_, names, deploys, findings := verifyHpa(diffs)
+	assert.Empty(t, findings)
 
-	applyLastAppliedConfigPatch(nil, nil, "fdp-connectivity-web-service-integration-usw2-ppd-prf", names, deploys, true)
+	applyLastAppliedConfigPatch(nil, nil, names, deploys, true)
 }
 
 const rolloutWithReplicas = `
@@ -184,10 +179,10 @@ const rolloutWithReplicas = `
 //Test HPA and noReplicas applied
 func TestRolloutWithReplicas(t *testing.T) {
 	diffs := toResourceDiffs(t, rolloutWithReplicas)
-	_, names, deploys, result := verifyHpa(diffs)
-	assert.EqualValues(t, result, 0)
+	_, names, deploys, findings := verifyHpa(diffs)
+	assert.Empty(t, findings)
 
-	applyLastAppliedConfigPatch(nil, nil, "fdp-connectivity-web-service-integration-usw2-prd-prd", names, deploys, true)
+	applyLastAppliedConfigPatch(nil, nil, names, deploys, true)
 }
 
 // Enable HPA
@@ -224,8 +219,8 @@ const RegularIngress = `
 
 func TestRegularIngress(t *testing.T) {
 	diffs := toResourceDiffs(t, RegularIngress)
-	result := verifyIngress(diffs)
-	assert.EqualValues(t, result, 0)
+	findings := verifyIngress(diffs, defaultIngressTargetTypeAnnotation, defaultIngressTargetType)
+	assert.Empty(t, findings)
 }
 
 const RegularIngressWithInstance = `
@@ -261,8 +256,8 @@ const RegularIngressWithInstance = `
 
 func TestRegularIngressWithInstance(t *testing.T) {
 	diffs := toResourceDiffs(t, RegularIngressWithInstance)
-	result := verifyIngress(diffs)
-	assert.EqualValues(t, result, 0)
+	findings := verifyIngress(diffs, defaultIngressTargetTypeAnnotation, defaultIngressTargetType)
+	assert.Empty(t, findings)
 }
 
 // IKS 2 ingress
@@ -298,18 +293,12 @@ const IKS2Ingress = `
 `
 
 func TestIKS2Ingress(t *testing.T) {
-	fakeExit := func(int) {
-		panic(500)
-	}
-	patch := monkey.Patch(os.Exit, fakeExit)
-	defer patch.Unpatch()
-
-	f := func() {
-		diffs := toResourceDiffs(t, IKS2Ingress)
-		verifyIngress(diffs)
-	}
-
-	assert.PanicsWithValue(t, 500, f)
+	diffs := toResourceDiffs(t, IKS2Ingress)
+	findings := verifyIngress(diffs, defaultIngressTargetTypeAnnotation, defaultIngressTargetType)
+	assert.EqualValues(t, len(findings), 1)
+	assert.EqualValues(t, findings[0].Rule, "ingress-readiness-gate-missing")
+	assert.EqualValues(t, findings[0].Name, "hpa-samples-appd-ingress")
+	assert.EqualValues(t, exitCode(findings, 0), exitCodeViolations)
 }
 
 // IKS 2 ingress
@@ -346,8 +335,8 @@ const IKS2IngressWithPodReadinessGate = `
 
 func TestIKS2IngressWithPodReadinessGate(t *testing.T) {
 	diffs := toResourceDiffs(t, IKS2IngressWithPodReadinessGate)
-	result := verifyIngress(diffs)
-	assert.EqualValues(t, result, 0)
+	findings := verifyIngress(diffs, defaultIngressTargetTypeAnnotation, defaultIngressTargetType)
+	assert.Empty(t, findings)
 }
 
 const ingressAndRollout = `
@@ -384,7 +373,41 @@ const ingressAndRollout = `
 //Test HPA and noReplicas applied
 func TestIngressAndRollout(t *testing.T) {
 	diffs := toResourceDiffs(t, ingressAndRollout)
-	result := verifyIngress(diffs)
-	assert.EqualValues(t, result, 0)
+	findings := verifyIngress(diffs, defaultIngressTargetTypeAnnotation, defaultIngressTargetType)
+	assert.Empty(t, findings)
+
+}
+
+func TestEvaluateAllGuards(t *testing.T) {
+	app := &GuardContext{
+		AppName:   "dev-containers-hpa-samples-usw2-ppd-qal",
+		Resources: toResourceDiffs(t, IKS2Ingress),
+		DryRun:    true,
+		Config:    &GuardConfig{},
+	}
+	report := evaluateGuards(app, Guards())
+	assert.EqualValues(t, report.guardErrors(), 0)
+	assert.EqualValues(t, len(report.Guards), len(Guards()))
+	assert.EqualValues(t, len(report.Findings), 1)
+	assert.EqualValues(t, report.Findings[0].Guard, "ingress")
+	assert.EqualValues(t, report.exitCode(), exitCodeViolations)
+}
+
+func TestSelectApps(t *testing.T) {
+	apps := []argoappv1.Application{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web-prd", Labels: map[string]string{"team": "payments"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "api-prd", Labels: map[string]string{"team": "payments", "tier": "backend"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "search-prd", Labels: map[string]string{"team": "search"}}},
+	}
+
+	names, err := selectApps(apps, "team=payments")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"api-prd", "web-prd"}, names)
+
+	names, err = selectApps(apps, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"api-prd", "search-prd", "web-prd"}, names)
 
+	_, err = selectApps(apps, "team in (")
+	assert.NotNil(t, err)
 }
@@ -0,0 +1,518 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/json"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register(&hpaGuard{})
+}
+
+// hpaGuard makes sure the objects scaled by a HorizontalPodAutoscaler don't manage 'spec.replicas' themselves
+type hpaGuard struct{}
+
+func (g *hpaGuard) Name() string {
+	return "hpa"
+}
+
+func (g *hpaGuard) Description() string {
+	return "Check HPA and specs of objects refereneced by HPA"
+}
+
+func (g *hpaGuard) Evaluate(app *GuardContext) ([]Finding, error) {
+	_, resourceNames, resources, findings := verifyHpa(app.Resources)
+
+	//Skipped resources are never patched, their findings and the ones the guard config disables, downgrades or excepts
+	//don't hold back the patches of the others
+	blocked := false
+	for _, finding := range findings {
+		finding.Guard = g.Name()
+		if app.Config.blocks(app.AppName, finding) && !app.skips(g.Name(), findingResource(app.Resources, finding)) {
+			blocked = true
+		}
+	}
+	for i, resourceName := range resourceNames {
+		if target := resources[resourceName]; resourceName != "" && target != nil && app.skips(g.Name(), target.resource) {
+			log.Infof("%s:%s skips guard '%s', it is not patched", target.resource.Kind, target.resource.Name, g.Name())
+			delete(resources, resourceName)
+			resourceNames[i] = ""
+		}
+	}
+
+	if blocked {
+		return append(findings, verifyHpaMetrics(app.Resources)...), nil
+	}
+
+	//Apply patches, missing requests don't keep the replicas from being patched
+	err := applyLastAppliedConfigPatch(app.Ctx, app.Patcher, resourceNames, resources, app.DryRun)
+	return append(findings, verifyHpaMetrics(app.Resources)...), err
+}
+
+func verifyHpa(resourceDiffs []*argoappv1.ResourceDiff) ([]*unstructured.Unstructured, []string, map[string]*scaleTarget, []Finding) {
+	hpas, resourceNames, resources := hpaReferencesObjects(resourceDiffs)
+	findings := make([]Finding, 0)
+
+	if len(hpas) == 0 {
+		log.Infof("No HPA found, good to pass through")
+		return nil, nil, nil, findings
+	}
+
+	for i := range resourceNames {
+		resourceName := resourceNames[i]
+		target := resources[resourceName]
+		if target == nil {
+			findings = append(findings, newFinding("hpa-target-missing", SeverityError, hpas[i],
+				fmt.Sprintf("The HPA:%s refer to a non-exists resource: %s", hpas[i].GetName(), scaleTargetRef(hpas[i])),
+				"Point 'spec.scaleTargetRef' to a Deployment, StatefulSet, ReplicaSet, Rollout or scalable custom resource of the application"))
+			resourceNames[i] = ""
+			continue
+		}
+		resource := target.resource
+		replicasField := target.replicasField()
+
+		resourceTarget, error := resource.TargetObject()
+		if error != nil || resourceTarget == nil {
+			findings = append(findings, newResourceFinding("hpa-target-invalid", SeverityWarning, resource,
+				fmt.Sprintf("The target object %s doesn't exist or has error %v", resourceName, error), ""))
+			resourceNames[i] = ""
+			continue
+		}
+		if replicas, found, _ := unstructured.NestedFieldNoCopy(resourceTarget.Object, target.replicasPath...); found && replicas != nil {
+			findings = append(findings, newResourceFinding("hpa-replicas-set", SeverityError, resource,
+				fmt.Sprintf("'%s' is set in %s:%s, but the replicas is managed by HPA:%s", replicasField, resource.Kind, resource.Name, hpas[i].GetName()),
+				fmt.Sprintf("Please set '%s' as null ('replicas: null') for kustomize template or delete '%s' if you use ksonnet", replicasField, replicasField)))
+			resourceNames[i] = ""
+			continue
+		}
+
+		resourceLive, error := resource.LiveObject()
+		if error != nil {
+			findings = append(findings, newResourceFinding("hpa-target-invalid", SeverityWarning, resource,
+				fmt.Sprintf("The live object has error %v", error), ""))
+			resourceNames[i] = ""
+			continue
+		}
+		if resourceLive == nil { //No object, first time roll out
+			delete(resources, resourceName)
+			resourceNames[i] = ""
+			continue
+		}
+
+		//Server-side apply keeps the ownership in 'metadata.managedFields' instead of last-applied-configuration
+		if applyManagers, managers := replicasManagers(resourceLive, target.replicasPath); len(applyManagers) != 0 {
+			if len(managers) > 1 {
+				log.Infof("'%s' of %s:%s is shared by the field managers %s, it keeps its value once the manifest drops it", replicasField, resource.Kind, resource.Name, strings.Join(managers, ", "))
+			} else {
+				replicas, _, _ := unstructured.NestedFieldNoCopy(resourceLive.Object, target.replicasPath...)
+				findings = append(findings, newResourceFinding("hpa-replicas-owned", SeverityError, resource,
+					fmt.Sprintf("'%s' of %s:%s is owned by the server-side apply field manager '%s' only, it would be reset once the manifest without it is applied",
+						replicasField, resource.Kind, resource.Name, applyManagers[0]),
+					fmt.Sprintf("Share the ownership with another field manager before syncing, so the HPA keeps the replica count: "+
+						"kubectl apply --server-side --field-manager=handover-to-hpa with a manifest of apiVersion, kind, name and '%s: %v' only, "+
+						"see https://kubernetes.io/docs/reference/using-api/server-side-apply/#transferring-ownership", replicasField, replicas)))
+			}
+			delete(resources, resourceName)
+			resourceNames[i] = ""
+			continue
+		}
+
+		var metadataObj = resourceLive.Object["metadata"]
+		if metadataObj != nil && reflect.TypeOf(metadataObj).String() == "map[string]interface {}" {
+			metadata := metadataObj.(map[string]interface{})
+			if metadata["annotations"] != nil && reflect.TypeOf(metadata["annotations"]).String() == "map[string]interface {}" {
+				annotations := metadata["annotations"].(map[string]interface{})
+
+				if lastAppliedConfiguration, ok := annotations["kubectl.kubernetes.io/last-applied-configuration"].(string); ok && lastAppliedConfiguration != "" {
+					var resourceLastApplied = &unstructured.Unstructured{}
+					err := json.Unmarshal([]byte(lastAppliedConfiguration), resourceLastApplied)
+					if err == nil {
+						if replicas, _, _ := unstructured.NestedFieldNoCopy(resourceLastApplied.Object, target.replicasPath...); replicas == nil { //The Deployment/Rollout doesn't have 'spec.replicas' it is in good state
+							log.Infof("%s:%s doesn't have '%s', it is managed by HPA, perfect!", resource.Kind, resource.Name, replicasField)
+							delete(resources, resourceName)
+							resourceNames[i] = ""
+							continue
+						}
+					}
+				}
+			}
+		}
+	}
+	return hpas, resourceNames, resources, findings
+}
+
+// hpaResourceMetric is a cpu or memory metric of an HPA, container is set for container resource metrics
+type hpaResourceMetric struct {
+	resource    string
+	container   string
+	utilization bool
+}
+
+func (m hpaResourceMetric) String() string {
+	if m.container != "" {
+		return fmt.Sprintf("%s of container '%s'", m.resource, m.container)
+	}
+	return m.resource
+}
+
+// hpaResourceMetrics returns the resource and container resource metrics of 'spec.metrics',
+// or the cpu utilization of autoscaling/v1 'spec.targetCPUUtilizationPercentage'
+func hpaResourceMetrics(hpa *unstructured.Unstructured) []hpaResourceMetric {
+	result := make([]hpaResourceMetric, 0)
+	metrics, _, _ := unstructured.NestedSlice(hpa.Object, "spec", "metrics")
+	if len(metrics) == 0 { //The API server defaults to cpu utilization without a target
+		return append(result, hpaResourceMetric{resource: "cpu", utilization: true})
+	}
+	for _, metric := range metrics {
+		metricObj := toMap(metric)
+		var source map[string]interface{}
+		switch metricObj["type"] {
+		case "Resource":
+			source = toMap(metricObj["resource"])
+		case "ContainerResource":
+			source = toMap(metricObj["containerResource"])
+		}
+		if source == nil {
+			continue
+		}
+		name, _ := source["name"].(string)
+		container, _ := source["container"].(string)
+		targetType, _, _ := unstructured.NestedString(source, "target", "type")
+		_, v2beta1Utilization := source["targetAverageUtilization"]
+		result = append(result, hpaResourceMetric{resource: name, container: container, utilization: targetType == "Utilization" || v2beta1Utilization})
+	}
+	return result
+}
+
+// verifyHpaMetrics makes sure the containers of the Deployments and Rollouts scaled on cpu or memory utilization
+// request the resource, the HPA can't compute the utilization otherwise
+func verifyHpaMetrics(resourceDiffs []*argoappv1.ResourceDiff) []Finding {
+	findings := make([]Finding, 0)
+	hpas, resourceNames, resources := hpaReferencesObjects(resourceDiffs)
+	defaultRequests := limitRangeDefaults(resourceDiffs)
+	for i, hpa := range hpas {
+		target := resources[resourceNames[i]]
+		if target == nil || !isWorkload(target.resource) {
+			continue
+		}
+		resource := target.resource
+		targetObj, err := resource.TargetObject()
+		if err != nil || targetObj == nil {
+			continue
+		}
+		template, err := podTemplate(targetObj)
+		if err != nil {
+			findings = append(findings, newResourceFinding("hpa-target-invalid", SeverityWarning, resource,
+				fmt.Sprintf("The pod template has error %v", err), ""))
+			continue
+		}
+
+		for _, metric := range hpaResourceMetrics(hpa) {
+			containers := template.Spec.Containers
+			if metric.container != "" {
+				containers = nil
+				for _, container := range template.Spec.Containers {
+					if container.Name == metric.container {
+						containers = append(containers, container)
+					}
+				}
+				if len(containers) == 0 {
+					findings = append(findings, newFinding("hpa-metric-container-missing", SeverityError, hpa,
+						fmt.Sprintf("The HPA:%s scales on the %s, but %s:%s has no such container", hpa.GetName(), metric, resource.Kind, resource.Name),
+						"Point 'containerResource.container' to a container of the pod template"))
+					continue
+				}
+			}
+			if !metric.utilization || defaultRequests[metric.resource] {
+				continue
+			}
+			for _, container := range containers {
+				name := corev1.ResourceName(metric.resource)
+				//The API server defaults missing requests to the limits
+				if _, ok := container.Resources.Requests[name]; ok {
+					continue
+				}
+				if _, ok := container.Resources.Limits[name]; ok {
+					continue
+				}
+				findings = append(findings, newResourceFinding("hpa-metric-request-missing", SeverityError, resource,
+					fmt.Sprintf("The HPA:%s scales on %s utilization, but the container '%s' of %s:%s has no %s request, the HPA can't compute the utilization",
+						hpa.GetName(), metric, container.Name, resource.Kind, resource.Name, metric.resource),
+					fmt.Sprintf("Set 'resources.requests.%s' of the container '%s'", metric.resource, container.Name)))
+			}
+		}
+	}
+	return findings
+}
+
+// limitRangeDefaults returns the resources a LimitRange of the application sets a default container request for
+func limitRangeDefaults(resourceDiffs []*argoappv1.ResourceDiff) map[string]bool {
+	result := make(map[string]bool)
+	for _, limitRange := range targetObjectsOfKind(resourceDiffs, "", "LimitRange") {
+		limits, _, _ := unstructured.NestedSlice(limitRange.Object, "spec", "limits")
+		for _, limit := range limits {
+			limitObj := toMap(limit)
+			if limitObj["type"] != "Container" {
+				continue
+			}
+			//The default limit is the default request too
+			for _, field := range []string{"defaultRequest", "default"} {
+				for name := range toMap(limitObj[field]) {
+					result[name] = true
+				}
+			}
+		}
+	}
+	return result
+}
+
+//Call ArgoCD or Kubernetes patch to apply "kubectl.kubernetes.io/last-applied-configuration" patch on DeploymentSpec and RolloutSpec
+func applyLastAppliedConfigPatch(ctx context.Context, patcher Patcher, resourceNames []string, resources map[string]*scaleTarget, dryRun bool) error {
+	var patchErr error
+	if len(resources) != 0 { //
+		//The remain deployments or rollouts need to be applied
+		for i := range resourceNames {
+			resourceName := resourceNames[i]
+			if resourceName != "" {
+				var target = resources[resourceName]
+				var resource = target.resource
+				var namespace = resource.Namespace
+				var liveObj, _ = resource.LiveObject()
+				liveObjCopy := liveObj.DeepCopy()
+				if namespace == "" {
+					namespace = liveObj.GetNamespace()
+				}
+
+				var lastAppliedConfig = ""
+
+				metadataObj := liveObjCopy.Object["metadata"]
+				if metadataObj != nil && reflect.TypeOf(metadataObj).String() == "map[string]interface {}" {
+					metadata := metadataObj.(map[string]interface{})
+					annoObj := metadata["annotations"]
+					if annoObj != nil && reflect.TypeOf(annoObj).String() == "map[string]interface {}" {
+						anno := annoObj.(map[string]interface{})
+						lastAppliedConfig, _ = anno["kubectl.kubernetes.io/last-applied-configuration"].(string)
+						delete(anno, "kubectl.kubernetes.io/last-applied-configuration")
+					}
+				}
+
+				var lastAppliedConfigObj *unstructured.Unstructured = nil
+				if lastAppliedConfig != "" { //Use the last-applied-configuration instead of liveObject
+					lastAppliedConfigObj = &unstructured.Unstructured{}
+					err := json.Unmarshal([]byte(lastAppliedConfig), lastAppliedConfigObj)
+					if err != nil {
+						log.Errorf("Not able to unmarshal last-applied-configuration %s %v", lastAppliedConfig, err)
+					}
+					liveObjCopy = lastAppliedConfigObj
+				}
+
+				unstructured.RemoveNestedField(liveObjCopy.Object, target.replicasPath...)
+				delete(liveObjCopy.Object, "status")
+
+				bytes, err := json.Marshal(liveObjCopy)
+				if err != nil {
+					return fmt.Errorf("not able to marshal %s Spec: %v", liveObjCopy.GetKind(), err)
+				}
+
+				newPatch := make(map[string]interface{})
+				newMetadata := make(map[string]interface{})
+				newPatch["metadata"] = newMetadata
+				newAnnotations := make(map[string]interface{})
+				newMetadata["annotations"] = newAnnotations
+				newAnnotations["kubectl.kubernetes.io/last-applied-configuration"] = string(bytes)
+
+				//For debug
+				//var tmpFileName = "/tmp/" + resourceName + strconv.FormatInt(time.Now().Unix(), 10) + ".yaml"
+				//f, err := os.Create(tmpFileName)
+				//if err != nil {
+				//	log.Errorf("Not able to create temp file:%s", tmpFileName)
+				//	os.Exit(1)
+				//}
+				////log.Infof("Writing current resource to yaml file:%s", tmpFileName)
+				yamlBytes, err := json.Marshal(newPatch)
+				//f.Write(yamlBytes)
+				//f.Sync()
+				//f.Close()
+
+				//var fileNames = make([]string, 1)
+				//fileNames[0] = tmpFileName
+
+				if !dryRun {
+					err = patcher.Patch(ctx, resource, namespace, liveObjCopy.GetAPIVersion(), yamlBytes)
+					if err != nil {
+						log.Errorf("Patching annoation 'kubectl.kubernetes.io/last-applied-configuration' on resource: %s, error:%v", resourceName, err)
+						patchErr = fmt.Errorf("not able to patch resource %s: %v", resourceName, err)
+					} else {
+						log.Infof("Resource '%s' patched on 'kubectl.kubernetes.io/last-applied-configuration'", resourceName)
+					}
+				} else {
+					log.Infof("DryRun on resource '%s' patch of 'kubectl.kubernetes.io/last-applied-configuration'", resourceName)
+				}
+			}
+		}
+	}
+	return patchErr
+}
+
+// replicasManagers returns the field managers of 'metadata.managedFields' owning the replicas field of the live object,
+// the server-side apply managers and all of them
+func replicasManagers(live *unstructured.Unstructured, replicasPath []string) ([]string, []string) {
+	applyManagers := make([]string, 0)
+	managers := make([]string, 0)
+	entries, _, _ := unstructured.NestedSlice(live.Object, "metadata", "managedFields")
+	for _, entry := range entries {
+		entryObj := toMap(entry)
+		fields := toMap(entryObj["fieldsV1"])
+		if fields == nil { //managedFields of Kubernetes 1.14 and 1.15
+			fields = toMap(entryObj["fields"])
+		}
+		for _, field := range replicasPath {
+			fields = toMap(fields["f:"+field])
+		}
+		if fields == nil {
+			continue
+		}
+		manager, _ := entryObj["manager"].(string)
+		managers = append(managers, manager)
+		if operation, _ := entryObj["operation"].(string); operation == "Apply" {
+			applyManagers = append(applyManagers, manager)
+		}
+	}
+	return applyManagers, managers
+}
+
+// scaleTarget is an object of the application scaled by an HPA
+type scaleTarget struct {
+	resource *argoappv1.ResourceDiff
+	// replicasPath is the path of the replicas field, 'spec.replicas' unless the CRD says otherwise
+	replicasPath []string
+}
+
+func (t *scaleTarget) replicasField() string {
+	return strings.Join(t.replicasPath, ".")
+}
+
+var defaultReplicasPath = []string{"spec", "replicas"}
+
+// hpaReferencesObjects finds all the resources that the HPA spec references.
+// The resources are matched on apiVersion, kind and name of 'spec.scaleTargetRef', so any HPA version could scale
+// Deployment, StatefulSet, ReplicaSet, Rollout or any custom resource with scale subresource.
+// The returned resource names are keys of the resources, see resourceKey.
+func hpaReferencesObjects(resourceDiffs []*argoappv1.ResourceDiff) ([]*unstructured.Unstructured, []string, map[string]*scaleTarget) {
+	hpaObjects := make([]*unstructured.Unstructured, 0)
+	resourceNames := make([]string, 0)
+	resources := make(map[string]*scaleTarget)
+
+	objects := make(map[string]*argoappv1.ResourceDiff)
+	for _, obj := range resourceDiffs {
+		objects[resourceKey(obj.Group, obj.Kind, obj.Name)] = obj
+	}
+	replicasPaths := customResourceReplicasPaths(resourceDiffs)
+
+	for i := range resourceDiffs {
+		obj := resourceDiffs[i]
+		if obj.Kind != "HorizontalPodAutoscaler" || obj.Group != "autoscaling" {
+			continue
+		}
+		targetObject, error := obj.TargetObject()
+		if error != nil || targetObject == nil {
+			continue
+		}
+		scaleTargetRef, found, _ := unstructured.NestedMap(targetObject.Object, "spec", "scaleTargetRef")
+		if !found {
+			continue
+		}
+		apiVersion, _ := scaleTargetRef["apiVersion"].(string)
+		kind, _ := scaleTargetRef["kind"].(string)
+		name, _ := scaleTargetRef["name"].(string)
+		if kind == "" || name == "" {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil {
+			log.Warnf("The HorizontalPodAutoscaler:%s has invalid scaleTargetRef apiVersion %s", targetObject.GetName(), apiVersion)
+			continue
+		}
+
+		key := resourceKey(gv.Group, kind, name)
+		resource := objects[key]
+		if resource == nil && apiVersion == "" { //apiVersion is optional in autoscaling/v1, match on kind and name only
+			for _, obj := range resourceDiffs {
+				if obj.Kind == kind && obj.Name == name {
+					key = resourceKey(obj.Group, kind, name)
+					resource = obj
+					break
+				}
+			}
+		}
+
+		hpaObjects = append(hpaObjects, targetObject.DeepCopy())
+		resourceNames = append(resourceNames, key)
+		if resource != nil {
+			replicasPath := defaultReplicasPath
+			if path, ok := replicasPaths[resourceKey(resource.Group, resource.Kind, "")]; ok {
+				replicasPath = path
+			}
+			resources[key] = &scaleTarget{resource: resource.DeepCopy(), replicasPath: replicasPath}
+		}
+
+		log.Infof("The HorizontalPodAutoscaler:%s is associated with %s:%s", targetObject.GetName(), kind, name)
+	}
+	return hpaObjects, resourceNames, resources
+}
+
+// scaleTargetRef describes 'spec.scaleTargetRef' of the HPA as "apiVersion Kind:name"
+func scaleTargetRef(hpa *unstructured.Unstructured) string {
+	apiVersion, _, _ := unstructured.NestedString(hpa.Object, "spec", "scaleTargetRef", "apiVersion")
+	kind, _, _ := unstructured.NestedString(hpa.Object, "spec", "scaleTargetRef", "kind")
+	name, _, _ := unstructured.NestedString(hpa.Object, "spec", "scaleTargetRef", "name")
+	return strings.TrimSpace(fmt.Sprintf("%s %s:%s", apiVersion, kind, name))
+}
+
+// customResourceReplicasPaths returns the 'specReplicasPath' of the scale subresource of the CRDs in the application by resourceKey without name
+func customResourceReplicasPaths(resourceDiffs []*argoappv1.ResourceDiff) map[string][]string {
+	paths := make(map[string][]string)
+	for _, crd := range targetObjectsOfKind(resourceDiffs, "apiextensions.k8s.io", "CustomResourceDefinition") {
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+		specReplicasPath, found, _ := unstructured.NestedString(crd.Object, "spec", "subresources", "scale", "specReplicasPath")
+		if !found { //apiextensions.k8s.io/v1 declares subresources per version
+			versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+			for _, version := range versions {
+				if specReplicasPath, found, _ = unstructured.NestedString(toMap(version), "subresources", "scale", "specReplicasPath"); found {
+					break
+				}
+			}
+		}
+		if found && specReplicasPath != "" {
+			paths[resourceKey(group, kind, "")] = strings.Split(strings.TrimPrefix(specReplicasPath, "."), ".")
+		}
+	}
+	return paths
+}
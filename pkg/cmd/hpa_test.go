@@ -0,0 +1,308 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func hpaManifest(apiVersion string, kind string, name string) string {
+	return `
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  minReplicas: 2
+  maxReplicas: 10
+  scaleTargetRef:
+    apiVersion: ` + apiVersion + `
+    kind: ` + kind + `
+    name: ` + name + `
+`
+}
+
+const hpaStatefulSet = `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  replicas: 3
+  serviceName: web
+`
+
+const hpaDeployment = `
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  replicas: 3
+`
+
+const hpaCustomResourceDefinition = `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: workers.example.com
+spec:
+  group: example.com
+  names:
+    kind: Worker
+    plural: workers
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+    subresources:
+      scale:
+        specReplicasPath: .spec.size
+        statusReplicasPath: .status.size
+`
+
+func TestHpaV2StatefulSet(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, hpaStatefulSet, hpaManifest("apps/v1", "StatefulSet", "web"))
+	_, _, _, findings := verifyHpa(diffs)
+	assert.Equal(t, []string{"hpa-replicas-set"}, findingRules(findings))
+	assert.Equal(t, "StatefulSet", findings[0].Kind)
+}
+
+func TestHpaKindMismatch(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, hpaDeployment, hpaManifest("apps/v1", "StatefulSet", "web"))
+	_, _, _, findings := verifyHpa(diffs)
+	assert.Equal(t, []string{"hpa-target-missing"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "apps/v1 StatefulSet:web")
+}
+
+func TestHpaExtensionsDeployment(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, hpaDeployment, hpaManifest("apps/v1", "Deployment", "web"))
+	_, _, _, findings := verifyHpa(diffs)
+	assert.Equal(t, []string{"hpa-replicas-set"}, findingRules(findings))
+	assert.Equal(t, "Deployment", findings[0].Kind)
+}
+
+func TestHpaCustomResourceReplicasPath(t *testing.T) {
+	worker := `
+apiVersion: example.com/v1
+kind: Worker
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  size: 3
+`
+	diffs := manifestsToResourceDiffs(t, hpaCustomResourceDefinition, worker, hpaManifest("example.com/v1", "Worker", "web"))
+	_, _, _, findings := verifyHpa(diffs)
+	assert.Equal(t, []string{"hpa-replicas-set"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "'spec.size'")
+
+	worker = `
+apiVersion: example.com/v1
+kind: Worker
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  replicas: 3
+`
+	diffs = manifestsToResourceDiffs(t, hpaCustomResourceDefinition, worker, hpaManifest("example.com/v1", "Worker", "web"))
+	_, _, _, findings = verifyHpa(diffs)
+	assert.Empty(t, findings)
+}
+
+func hpaLiveStatefulSet(managedFields string) string {
+	return `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: web
+  namespace: web-qal
+  managedFields:
+` + managedFields + `
+spec:
+  replicas: 5
+  serviceName: web
+`
+}
+
+const hpaArgoCDManagedFields = `
+  - manager: argocd-controller
+    operation: Apply
+    apiVersion: apps/v1
+    fieldsType: FieldsV1
+    fieldsV1:
+      f:spec:
+        f:replicas: {}
+        f:serviceName: {}
+`
+
+const hpaControllerManagedFields = `
+  - manager: kube-controller-manager
+    operation: Update
+    apiVersion: apps/v1
+    subresource: scale
+    fieldsType: FieldsV1
+    fieldsV1:
+      f:spec:
+        f:replicas: {}
+`
+
+func TestHpaServerSideApply(t *testing.T) {
+	target := strings.Replace(hpaStatefulSet, "  replicas: 3\n", "", 1)
+	objs, err := decodeManifests(strings.NewReader(target+"\n---\n"+hpaManifest("apps/v1", "StatefulSet", "web")), "test")
+	assert.Nil(t, err)
+
+	lives, err := decodeManifests(strings.NewReader(hpaLiveStatefulSet(hpaArgoCDManagedFields)), "test")
+	assert.Nil(t, err)
+	diffs, err := manifestResourceDiffs(objs, lives)
+	assert.Nil(t, err)
+	_, _, resources, findings := verifyHpa(diffs)
+	assert.Equal(t, []string{"hpa-replicas-owned"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "'argocd-controller'")
+	assert.Contains(t, findings[0].Fix, "'spec.replicas: 5'")
+	assert.Empty(t, resources)
+
+	lives, err = decodeManifests(strings.NewReader(hpaLiveStatefulSet(hpaArgoCDManagedFields+hpaControllerManagedFields)), "test")
+	assert.Nil(t, err)
+	diffs, err = manifestResourceDiffs(objs, lives)
+	assert.Nil(t, err)
+	_, _, resources, findings = verifyHpa(diffs)
+	assert.Empty(t, findings)
+	assert.Empty(t, resources)
+
+	lives, err = decodeManifests(strings.NewReader(hpaLiveStatefulSet(hpaControllerManagedFields)), "test")
+	assert.Nil(t, err)
+	applyManagers, managers := replicasManagers(lives[0], defaultReplicasPath)
+	assert.Empty(t, applyManagers)
+	assert.Equal(t, []string{"kube-controller-manager"}, managers)
+}
+
+func hpaMetricsDeployment(resources string) string {
+	return `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: web:latest
+` + resources + `
+      - name: proxy
+        image: envoy:latest
+        resources:
+          requests:
+            cpu: 100m
+`
+}
+
+func hpaMetricsManifest(spec string) string {
+	return `
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  maxReplicas: 10
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web
+` + spec
+}
+
+func TestHpaMetricsRequests(t *testing.T) {
+	v1 := `
+apiVersion: autoscaling/v1
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  maxReplicas: 10
+  targetCPUUtilizationPercentage: 70
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web
+`
+	findings := verifyHpaMetrics(manifestsToResourceDiffs(t, hpaMetricsDeployment(""), v1))
+	assert.Equal(t, []string{"hpa-metric-request-missing"}, findingRules(findings))
+	assert.Equal(t, "Deployment", findings[0].Kind)
+	assert.Contains(t, findings[0].Message, "container 'app'")
+	assert.Contains(t, findings[0].Fix, "'resources.requests.cpu'")
+
+	limits := `
+        resources:
+          limits:
+            cpu: 500m
+`
+	assert.Empty(t, verifyHpaMetrics(manifestsToResourceDiffs(t, hpaMetricsDeployment(limits), v1)))
+
+	memory := hpaMetricsManifest(`
+  metrics:
+  - type: Resource
+    resource:
+      name: memory
+      target:
+        type: Utilization
+        averageUtilization: 80
+  - type: Resource
+    resource:
+      name: cpu
+      target:
+        type: AverageValue
+        averageValue: 200m
+`)
+	findings = verifyHpaMetrics(manifestsToResourceDiffs(t, hpaMetricsDeployment(limits), memory))
+	assert.Equal(t, []string{"hpa-metric-request-missing", "hpa-metric-request-missing"}, findingRules(findings))
+	assert.Contains(t, findings[1].Message, "container 'proxy'")
+
+	limitRange := `
+apiVersion: v1
+kind: LimitRange
+metadata:
+  name: defaults
+  namespace: web-qal
+spec:
+  limits:
+  - type: Container
+    defaultRequest:
+      memory: 128Mi
+`
+	assert.Empty(t, verifyHpaMetrics(manifestsToResourceDiffs(t, hpaMetricsDeployment(limits), memory, limitRange)))
+}
+
+func TestHpaMetricsContainerResource(t *testing.T) {
+	hpa := hpaMetricsManifest(`
+  metrics:
+  - type: ContainerResource
+    containerResource:
+      name: cpu
+      container: proxy
+      target:
+        type: Utilization
+        averageUtilization: 60
+  - type: ContainerResource
+    containerResource:
+      name: cpu
+      container: sidecar
+      target:
+        type: Utilization
+        averageUtilization: 60
+`)
+	findings := verifyHpaMetrics(manifestsToResourceDiffs(t, hpaMetricsDeployment(""), hpa))
+	assert.Equal(t, []string{"hpa-metric-container-missing"}, findingRules(findings))
+	assert.Equal(t, "HorizontalPodAutoscaler", findings[0].Kind)
+	assert.Contains(t, findings[0].Message, "cpu of container 'sidecar'")
+}
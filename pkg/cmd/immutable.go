@@ -0,0 +1,197 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register(&immutableGuard{})
+}
+
+// immutableGuard finds the changes of immutable fields before the sync, the API server would reject them halfway
+type immutableGuard struct{}
+
+func (g *immutableGuard) Name() string {
+	return "immutable"
+}
+
+func (g *immutableGuard) Description() string {
+	return "Check changes of immutable fields which need delete and recreate"
+}
+
+func (g *immutableGuard) Evaluate(app *GuardContext) ([]Finding, error) {
+	return verifyImmutableFields(app.Resources), nil
+}
+
+// immutableField is a path the API server refuses to update
+type immutableField struct {
+	path []string
+	// defaulted fields are filled in by the API server, only the values the manifest sets are compared.
+	// The others are compared as a whole, e.g. removing a label of a selector is a change.
+	defaulted bool
+}
+
+func atomicField(path ...string) immutableField {
+	return immutableField{path: path}
+}
+
+func defaultedField(path ...string) immutableField {
+	return immutableField{path: path, defaulted: true}
+}
+
+// immutableFields are the immutable fields by the group and kind of the resource.
+// The workloads of "extensions" are not listed, their selector could still be updated.
+var immutableFields = map[schema.GroupKind][]immutableField{
+	{Group: "apps", Kind: "Deployment"}:                              {atomicField("spec", "selector")},
+	{Group: "apps", Kind: "ReplicaSet"}:                              {atomicField("spec", "selector")},
+	{Group: "apps", Kind: "DaemonSet"}:                               {atomicField("spec", "selector")},
+	{Group: "apps", Kind: "StatefulSet"}:                             {atomicField("spec", "selector"), atomicField("spec", "serviceName"), defaultedField("spec", "volumeClaimTemplates"), atomicField("spec", "podManagementPolicy")},
+	{Group: "batch", Kind: "Job"}:                                    {atomicField("spec", "selector"), defaultedField("spec", "template")},
+	{Group: "", Kind: "Service"}:                                     {atomicField("spec", "clusterIP")},
+	{Group: "", Kind: "PersistentVolumeClaim"}:                       {atomicField("spec", "storageClassName"), atomicField("spec", "accessModes"), atomicField("spec", "selector"), atomicField("spec", "volumeName")},
+	{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}:        {atomicField("roleRef")},
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}: {atomicField("roleRef")},
+	{Group: "storage.k8s.io", Kind: "StorageClass"}:                  {atomicField("provisioner"), atomicField("parameters"), atomicField("reclaimPolicy"), atomicField("volumeBindingMode")},
+	{Group: "argoproj.io", Kind: "Rollout"}:                          {atomicField("spec", "selector")},
+}
+
+// mutableSelectorAPIVersions still allow updates of the workload selector, it is immutable since apps/v1beta2
+var mutableSelectorAPIVersions = map[string]bool{"apps/v1beta1": true}
+
+// replaceSyncOptions let Argo CD delete and recreate the resource instead of updating it
+const replaceSyncOptions = "Force=true,Replace=true"
+
+func verifyImmutableFields(resourceDiffs []*argoappv1.ResourceDiff) []Finding {
+	findings := make([]Finding, 0)
+	for _, resource := range resourceDiffs {
+		fields, ok := immutableFields[schema.GroupKind{Group: resource.Group, Kind: resource.Kind}]
+		if !ok {
+			continue
+		}
+		target, err := resource.TargetObject()
+		if err != nil || target == nil {
+			continue
+		}
+		live, err := resource.LiveObject()
+		if err != nil || live == nil { //Not deployed yet
+			continue
+		}
+
+		changed := make([]string, 0)
+		for _, field := range fields {
+			path := strings.Join(field.path, ".")
+			if path == "spec.selector" && resource.Group == "apps" && mutableSelectorAPIVersions[target.GetAPIVersion()] {
+				continue
+			}
+			targetValue, found, _ := unstructured.NestedFieldNoCopy(target.Object, field.path...)
+			if !found || targetValue == nil { //Fields the manifest doesn't set keep their live value
+				continue
+			}
+			liveValue, _, _ := unstructured.NestedFieldNoCopy(live.Object, field.path...)
+			if !subsetOf(targetValue, liveValue) || (!field.defaulted && !subsetOf(liveValue, targetValue)) {
+				changed = append(changed, path)
+			}
+		}
+		if resource.Group == "" && resource.Kind == "Service" && serviceTypeChangeNeedsRecreate(target, live) {
+			changed = append(changed, "spec.type")
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		severity := SeverityError
+		message := fmt.Sprintf("%s:%s changes the immutable '%s', the API server would reject the sync", resource.Kind, resource.Name, strings.Join(changed, "', '"))
+		if syncOptions := target.GetAnnotations()["argocd.argoproj.io/sync-options"]; strings.Contains(syncOptions, "Replace=true") && strings.Contains(syncOptions, "Force=true") {
+			severity = SeverityInfo
+			message = fmt.Sprintf("%s:%s changes the immutable '%s', it is going to be deleted and recreated by the sync", resource.Kind, resource.Name, strings.Join(changed, "', '"))
+		}
+		findings = append(findings, newResourceFinding("immutable-field-changed", severity, resource, message,
+			fmt.Sprintf("Delete and recreate %s:%s, or let Argo CD do it with the annotation 'argocd.argoproj.io/sync-options: %s'", resource.Kind, resource.Name, replaceSyncOptions)))
+	}
+
+	if len(findings) == 0 {
+		log.Infof("No immutable field is changed, good to pass through")
+	}
+	return findings
+}
+
+// serviceTypeChangeNeedsRecreate tells whether the Service turns into an ExternalName Service while the live one has a cluster IP,
+// the cluster IP the update keeps is not allowed for ExternalName Services
+func serviceTypeChangeNeedsRecreate(target *unstructured.Unstructured, live *unstructured.Unstructured) bool {
+	targetType, _, _ := unstructured.NestedString(target.Object, "spec", "type")
+	liveType, _, _ := unstructured.NestedString(live.Object, "spec", "type")
+	clusterIP, _, _ := unstructured.NestedString(live.Object, "spec", "clusterIP")
+	return targetType == "ExternalName" && liveType != "ExternalName" && clusterIP != "" && clusterIP != "None"
+}
+
+// subsetOf tells whether every field set in the target has the same value in the live object,
+// so the fields defaulted by the API server are not taken as changes
+func subsetOf(target interface{}, live interface{}) bool {
+	switch t := target.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return len(t) == 0 && live == nil
+		}
+		for key, value := range t {
+			if !subsetOf(value, l[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(t) != len(l) {
+			return len(t) == 0 && live == nil
+		}
+		for i := range t {
+			if !subsetOf(t[i], l[i]) {
+				return false
+			}
+		}
+		return true
+	case nil:
+		return true
+	}
+	if targetNumber, ok := number(target); ok {
+		liveNumber, ok := number(live)
+		return ok && targetNumber == liveNumber
+	}
+	return target == live
+}
+
+// number converts the numbers of unstructured objects, they are int64 or float64 depending on the decoder
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
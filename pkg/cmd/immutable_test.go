@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const immutableStatefulSet = `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: db-qal
+spec:
+  serviceName: db
+  selector:
+    matchLabels:
+      app: db
+  template:
+    metadata:
+      labels:
+        app: db
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      accessModes: [ReadWriteOnce]
+      resources:
+        requests:
+          storage: 10Gi
+`
+
+// immutableLiveStatefulSet is the StatefulSet with the fields defaulted by the API server
+const immutableLiveStatefulSet = `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: db-qal
+spec:
+  serviceName: db
+  podManagementPolicy: OrderedReady
+  replicas: 1
+  selector:
+    matchLabels:
+      app: db
+  template:
+    metadata:
+      labels:
+        app: db
+  volumeClaimTemplates:
+  - apiVersion: v1
+    kind: PersistentVolumeClaim
+    metadata:
+      name: data
+    spec:
+      accessModes: [ReadWriteOnce]
+      volumeMode: Filesystem
+      resources:
+        requests:
+          storage: 10Gi
+    status:
+      phase: Pending
+`
+
+func immutableDiffs(t *testing.T, target string, live string) []Finding {
+	targets, err := decodeManifests(strings.NewReader(target), "test")
+	assert.Nil(t, err)
+	lives, err := decodeManifests(strings.NewReader(live), "test")
+	assert.Nil(t, err)
+	diffs, err := manifestResourceDiffs(targets, lives)
+	assert.Nil(t, err)
+	return verifyImmutableFields(diffs)
+}
+
+func TestImmutableUnchanged(t *testing.T) {
+	assert.Empty(t, immutableDiffs(t, immutableStatefulSet, immutableLiveStatefulSet))
+	assert.Empty(t, immutableDiffs(t, immutableStatefulSet, ""))
+}
+
+func TestImmutableChanged(t *testing.T) {
+	target := strings.Replace(immutableStatefulSet, "storage: 10Gi", "storage: 20Gi", 1)
+	target = strings.Replace(target, "serviceName: db", "serviceName: db-headless", 1)
+	findings := immutableDiffs(t, target, immutableLiveStatefulSet)
+	assert.Equal(t, []string{"immutable-field-changed"}, findingRules(findings))
+	assert.Equal(t, SeverityError, findings[0].Severity)
+	assert.Contains(t, findings[0].Message, "'spec.serviceName', 'spec.volumeClaimTemplates'")
+
+	target = strings.Replace(target, "  namespace: db-qal\n", "  namespace: db-qal\n  annotations:\n    argocd.argoproj.io/sync-options: Force=true,Replace=true\n", 1)
+	findings = immutableDiffs(t, target, immutableLiveStatefulSet)
+	assert.Equal(t, SeverityInfo, findings[0].Severity)
+}
+
+func TestImmutableServiceType(t *testing.T) {
+	live := `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: ClusterIP
+  clusterIP: 10.0.0.10
+`
+	target := `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: ExternalName
+  externalName: web.example.com
+`
+	findings := immutableDiffs(t, target, live)
+	assert.Equal(t, []string{"immutable-field-changed"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "'spec.type'")
+
+	findings = immutableDiffs(t, strings.Replace(live, "10.0.0.10", "None", 1), live)
+	assert.Contains(t, findings[0].Message, "'spec.clusterIP'")
+}
+
+func TestImmutableSelectorKeyRemoved(t *testing.T) {
+	live := strings.Replace(immutableLiveStatefulSet, "      app: db\n  template", "      app: db\n      tier: backend\n  template", 1)
+	findings := immutableDiffs(t, immutableStatefulSet, live)
+	assert.Equal(t, []string{"immutable-field-changed"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "'spec.selector'")
+}
+
+func TestImmutableSelectorByAPIVersion(t *testing.T) {
+	deployment := func(apiVersion string, app string) string {
+		return `
+apiVersion: ` + apiVersion + `
+kind: Deployment
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  selector:
+    matchLabels:
+      app: ` + app + `
+`
+	}
+	assert.Equal(t, []string{"immutable-field-changed"}, findingRules(immutableDiffs(t, deployment("apps/v1", "web"), deployment("apps/v1", "api"))))
+	assert.Empty(t, immutableDiffs(t, deployment("apps/v1beta1", "web"), deployment("apps/v1beta1", "api")))
+	assert.Empty(t, immutableDiffs(t, deployment("extensions/v1beta1", "web"), deployment("extensions/v1beta1", "api")))
+}
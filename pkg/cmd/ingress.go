@@ -0,0 +1,340 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register(&ingressGuard{})
+}
+
+// ingressGuard is to enforce Deployment object has "PodReadinessCondition for ALB-Ingress" when target-type is "ip" in Ingress
+type ingressGuard struct{}
+
+func (g *ingressGuard) Name() string {
+	return "ingress"
+}
+
+func (g *ingressGuard) Description() string {
+	return "Check Ingress and Deployment"
+}
+
+// Default settings of the ingress guard, they could be changed in the guard config
+const (
+	defaultIngressTargetTypeAnnotation = "alb.ingress.kubernetes.io/target-type"
+	defaultIngressTargetType           = "ip"
+	defaultIngressDocumentation        = "https://github.intuit.com/kubernetes/modern-saas-docs/blob/master/docs/developer/msaas_resiliency_iks2.md"
+)
+
+func (g *ingressGuard) Evaluate(app *GuardContext) ([]Finding, error) {
+	targetTypeAnnotation := app.Config.setting(g.Name(), "targetTypeAnnotation", defaultIngressTargetTypeAnnotation)
+	targetType := app.Config.setting(g.Name(), "targetType", defaultIngressTargetType)
+	return verifyIngress(app.Resources, targetTypeAnnotation, targetType), nil
+}
+
+// verifyIngress makes sure every Ingress whose targetTypeAnnotation is targetType has a pod readiness gate pointing to it
+func verifyIngress(resourceDiffs []*argoappv1.ResourceDiff, targetTypeAnnotation string, targetType string) []Finding {
+	findings := make([]Finding, 0)
+	ingresses, resources := ingressAndDeployment(resourceDiffs)
+	services := servicesByName(resourceDiffs)
+	if len(ingresses) == 0 {
+		log.Infof("No Ingress found, good to pass through")
+		return findings
+	}
+
+	// Ingress Name -->  True or False
+	podReadinessGateEnabled := make(map[string]bool)
+	ingressMap := make(map[string]*unstructured.Unstructured)
+
+	// Set the mapping to false if the ingress has annotation alb.ingress.kubernetes.io/target-type=ip (by default)
+	for i := range ingresses {
+		ingress := ingresses[i]
+		ingressName := ingress.GetName()
+		var metadataObj = ingress.Object["metadata"]
+		if metadataObj != nil && reflect.TypeOf(metadataObj).String() == "map[string]interface {}" {
+			metadata := metadataObj.(map[string]interface{})
+			if metadata["annotations"] != nil && reflect.TypeOf(metadata["annotations"]).String() == "map[string]interface {}" {
+				annotations := metadata["annotations"].(map[string]interface{})
+
+				var lastAppliedConfiguration = annotations[targetTypeAnnotation]
+				if lastAppliedConfiguration == targetType {
+					podReadinessGateEnabled[ingressName] = false
+				}
+			}
+		}
+		ingressMap[ingressName] = ingress
+	}
+
+	if len(podReadinessGateEnabled) == 0 { //No ingress object,
+		log.Infof("No Ingress has annotation '%s=%s', good to pass through", targetTypeAnnotation, targetType)
+		return findings
+	}
+
+	// Each Ingress should have at least one PodReadinessGate points to it
+	// Resources could be Deployment or Rollout
+	for i := range resources {
+		resource := resources[i]
+
+		resourceTarget, error := resource.TargetObject()
+		if error != nil || resourceTarget == nil {
+			findings = append(findings, newResourceFinding("ingress-workload-invalid", SeverityWarning, resource,
+				fmt.Sprintf("The target object has error %v", error), ""))
+			continue
+		}
+
+		specObj := resourceTarget.Object["spec"]
+		if specObj != nil && reflect.TypeOf(specObj).String() == "map[string]interface {}" {
+			spec := specObj.(map[string]interface{})
+
+			templateObj := spec["template"]
+			if templateObj != nil && reflect.TypeOf(templateObj).String() == "map[string]interface {}" {
+				template := templateObj.(map[string]interface{})
+
+				if template["spec"] != nil && reflect.TypeOf(template["spec"]).String() == "map[string]interface {}" {
+					templateSpec := template["spec"].(map[string]interface{})
+
+					if templateSpec["readinessGates"] != nil && reflect.TypeOf(templateSpec["readinessGates"]).String() == "[]interface {}" {
+
+						readinessGates := templateSpec["readinessGates"].([]interface{})
+
+						if len(readinessGates) > 0 {
+							for j := range readinessGates {
+								conditionObj := readinessGates[j]
+
+								var conditionType = ""
+								if reflect.TypeOf(conditionObj).String() == "map[string]interface {}" {
+									condition := conditionObj.(map[string]interface{})
+									if condition["conditionType"] != nil {
+										conditionType = condition["conditionType"].(string)
+									}
+								} else if reflect.TypeOf(conditionObj).String() == "map[string]string" {
+									condition := conditionObj.(map[string]string)
+									if condition["conditionType"] != "" {
+										conditionType = condition["conditionType"]
+									}
+								}
+
+								if strings.HasPrefix(conditionType, "target-health.alb.ingress.k8s.aws/") {
+									var l = len("target-health.alb.ingress.k8s.aws/")
+									var suffix = conditionType[l:]
+									if len(suffix) > 0 {
+										if len(suffix) > 63 { //Bug in alb-ingress-controller https://github.intuit.com/kubernetes/arktika/issues/935#issuecomment-1107371
+											// https://github.com/kubernetes-sigs/aws-alb-ingress-controller/issues/1217
+											findings = append(findings, newResourceFinding("ingress-condition-too-long", SeverityError, resource,
+												fmt.Sprintf("The pod readiness conditionType '%s' is more than 63 characters which a limitation from k8s", suffix),
+												"Please use static conditionType 'load-balancer-tg-ready' instead"))
+											continue
+										}
+										if suffix == "load-balancer-any-tg-ready" || suffix == "load-balancer-all-tg-ready" { // In this case, cd-guard will allow all Ingress passed
+											podReadinessGateEnabled["*"] = true
+										} else {
+											var array = strings.Split(suffix, "_")
+											if len(array) != 3 {
+												findings = append(findings, newResourceFinding("ingress-condition-malformed", SeverityError, resource,
+													fmt.Sprintf("The pod readiness condition %s doesn't have 3 parts separated with '_'", conditionType),
+													"The right syntax is 'INGRESS_SERVICE_PORT'"))
+											} else {
+												ingressName := array[0]
+												if ingress, ok := ingressMap[ingressName]; ok {
+													if _, hasKey := podReadinessGateEnabled[ingressName]; hasKey {
+														//Check whether the service and port are existing.
+														if goodStatus := verifyIngressServicePort(ingress, services, array[1], array[2]); goodStatus {
+															podReadinessGateEnabled[ingressName] = true
+														} else {
+															findings = append(findings, newResourceFinding("ingress-service-port-missing", SeverityError, resource,
+																fmt.Sprintf("The service name or port [%s:%s] deson't exist in ingress %s", array[1], array[2], ingressName),
+																"Point the pod readiness condition to a service and port used by the Ingress backends"))
+														}
+													} else { //Pod Readiness Condition points to an Ingress doesn't have target-type=ip annotation
+														findings = append(findings, newResourceFinding("ingress-target-type-missing", SeverityError, resource,
+															fmt.Sprintf("You have a pod readiness condition, but the Ingress %s doesn't have an annotation '%s' with value '%s'", ingressName, targetTypeAnnotation, targetType),
+															fmt.Sprintf("Add annotation '%s: %s' to the Ingress or remove the pod readiness condition", targetTypeAnnotation, targetType)))
+													}
+												} else { //Pod Readiness Condition points to a non-exists Ingress
+													findings = append(findings, newResourceFinding("ingress-missing", SeverityError, resource,
+														fmt.Sprintf("You have a pod readiness condition, but the Ingress %s doesn't exist", ingressName),
+														"Point the pod readiness condition to an Ingress of the application"))
+												}
+											}
+										}
+									} else {
+										findings = append(findings, newResourceFinding("ingress-condition-empty", SeverityError, resource,
+											fmt.Sprintf("The pod readiness condition %s doesn't point to the right INGRESS_SERVICE_PORT", conditionType),
+											"The right syntax is 'target-health.alb.ingress.k8s.aws/INGRESS_SERVICE_PORT'"))
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for ingressName, gateEnabled := range podReadinessGateEnabled {
+		if !gateEnabled {
+			if !(podReadinessGateEnabled["*"]) { //If there is static conditionType, we don't check whether the pods belongs to Ingress, instead just let the Ingress pass through.
+				finding := newFinding("ingress-readiness-gate-missing", SeverityError, ingressMap[ingressName],
+					fmt.Sprintf("Ingress '%s' with flat network, but no pod enables PodReadinessGate", ingressName),
+					"Add pod readiness gate 'target-health.alb.ingress.k8s.aws/INGRESS_SERVICE_PORT' to the Deployment or Rollout")
+				finding.Documentation = defaultIngressDocumentation
+				findings = append(findings, finding)
+			}
+		}
+	}
+
+	return findings
+}
+
+// ingressBackend is a service backend of an Ingress, port is either the port number or the port name
+type ingressBackend struct {
+	serviceName string
+	port        string
+}
+
+// ingressBackends returns all service backends of an Ingress, both extensions/v1beta1 and networking.k8s.io/v1 are supported
+func ingressBackends(ingress *unstructured.Unstructured) []ingressBackend {
+	backends := make([]ingressBackend, 0)
+	specObj := ingress.Object["spec"]
+	if specObj != nil && reflect.TypeOf(specObj).String() == "map[string]interface {}" {
+		spec := specObj.(map[string]interface{})
+		// 1. Single Service Ingress https://kubernetes.io/docs/concepts/services-networking/ingress/#single-service-ingress
+		/*
+		  backend:                  # extensions/v1beta1
+		    serviceName: testsvc
+		    servicePort: 80
+		  defaultBackend:           # networking.k8s.io/v1
+		    service:
+		      name: testsvc
+		      port:
+		        number: 80
+		*/
+		for _, key := range []string{"backend", "defaultBackend"} {
+			if backend, ok := toIngressBackend(spec[key]); ok {
+				backends = append(backends, backend)
+			}
+		}
+
+		// 2. Simple fanout https://kubernetes.io/docs/concepts/services-networking/ingress/#simple-fanout
+		// spec/rules[]/http/paths[]/backend
+		rulesObj := spec["rules"]
+		if rulesObj != nil && reflect.TypeOf(rulesObj).String() == "[]interface {}" {
+			rules := rulesObj.([]interface{})
+			for _, ruleObj := range rules {
+				paths, _, _ := unstructured.NestedSlice(toMap(ruleObj), "http", "paths")
+				for _, pathObj := range paths {
+					if backend, ok := toIngressBackend(toMap(pathObj)["backend"]); ok {
+						backends = append(backends, backend)
+					}
+				}
+			}
+		}
+	}
+	return backends
+}
+
+// toIngressBackend converts a backend of either extensions/v1beta1 or networking.k8s.io/v1 Ingress
+func toIngressBackend(backendObj interface{}) (ingressBackend, bool) {
+	backend := toMap(backendObj)
+	if serviceName, ok := backend["serviceName"].(string); ok { //extensions/v1beta1, servicePort is a number or a name
+		return ingressBackend{serviceName: serviceName, port: portString(backend["servicePort"])}, true
+	}
+	if serviceName, ok, _ := unstructured.NestedString(backend, "service", "name"); ok { //networking.k8s.io/v1
+		if portName, ok, _ := unstructured.NestedString(backend, "service", "port", "name"); ok && portName != "" {
+			return ingressBackend{serviceName: serviceName, port: portName}, true
+		}
+		portNumber, _, _ := unstructured.NestedFieldNoCopy(backend, "service", "port", "number")
+		return ingressBackend{serviceName: serviceName, port: portString(portNumber)}, true
+	}
+	return ingressBackend{}, false
+}
+
+// verifyIngressServicePort checks whether the Ingress has a backend of the service and port, named ports are resolved through the Service
+func verifyIngressServicePort(ingress *unstructured.Unstructured, services map[string]*unstructured.Unstructured, serviceName string, port string) bool {
+	for _, backend := range ingressBackends(ingress) {
+		if !strings.EqualFold(backend.serviceName, serviceName) {
+			continue
+		}
+		if backend.port == port {
+			return true
+		}
+		service := services[backend.serviceName]
+		if service != nil && servicePortNumber(service, backend.port) != "" && servicePortNumber(service, backend.port) == servicePortNumber(service, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// servicePortNumber resolves a port name or number to the port number of the Service, it returns "" if the Service has no such port
+func servicePortNumber(service *unstructured.Unstructured, port string) string {
+	ports, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+	for _, portObj := range ports {
+		servicePort := toMap(portObj)
+		number := portString(servicePort["port"])
+		if number == port || (servicePort["name"] != nil && servicePort["name"] == port) {
+			return number
+		}
+	}
+	return ""
+}
+
+// portString formats a port number or a port name
+func portString(port interface{}) string {
+	switch p := port.(type) {
+	case int64:
+		return strconv.FormatInt(p, 10)
+	case int:
+		return strconv.Itoa(p)
+	case float64:
+		return strconv.FormatInt(int64(p), 10)
+	case string:
+		return p
+	}
+	return ""
+}
+
+func ingressAndDeployment(resourceDiffs []*argoappv1.ResourceDiff) ([]*unstructured.Unstructured, map[string]*argoappv1.ResourceDiff) {
+	ingressObjects := make([]*unstructured.Unstructured, 0)
+	deploymentOrRollout := make(map[string]*argoappv1.ResourceDiff)
+
+	for i := range resourceDiffs {
+		obj := resourceDiffs[i]
+		if obj.Kind == "Ingress" {
+			targetObject, error := obj.TargetObject()
+			if error == nil && targetObject != nil {
+				copy := targetObject.DeepCopy()
+				ingressObjects = append(ingressObjects, copy)
+			}
+		} else if obj.Kind == "Deployment" {
+			deploymentOrRollout[obj.Name] = obj.DeepCopy()
+		} else if obj.Kind == "Rollout" && obj.Group == "argoproj.io" {
+			deploymentOrRollout[obj.Name] = obj.DeepCopy()
+		}
+	}
+	return ingressObjects, deploymentOrRollout
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const ingressV1Deployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      readinessGates:
+      - conditionType: target-health.alb.ingress.k8s.aws/web-ingress_web_PORT
+      containers:
+      - name: app
+        image: web:latest
+`
+
+const ingressV1Service = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  selector:
+    app: web
+  ports:
+  - name: https
+    port: 443
+    targetPort: 8443
+`
+
+const ingressV1Rules = `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web-ingress
+  annotations:
+    alb.ingress.kubernetes.io/target-type: ip
+spec:
+  rules:
+  - http:
+      paths:
+      - path: /
+        pathType: Prefix
+        backend:
+          service:
+            name: web
+            port:
+              name: https
+`
+
+const ingressV1DefaultBackend = `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web-ingress
+  annotations:
+    alb.ingress.kubernetes.io/target-type: ip
+spec:
+  defaultBackend:
+    service:
+      name: web
+      port:
+        number: 443
+`
+
+func verifyIngressManifests(t *testing.T, ingress string, port string) []Finding {
+	deployment := strings.Replace(ingressV1Deployment, "PORT", port, 1)
+	diffs := manifestsToResourceDiffs(t, ingress, ingressV1Service, deployment)
+	return verifyIngress(diffs, defaultIngressTargetTypeAnnotation, defaultIngressTargetType)
+}
+
+func TestIngressV1NamedPort(t *testing.T) {
+	assert.Empty(t, verifyIngressManifests(t, ingressV1Rules, "https"))
+	// The named port of the backend is resolved through the Service
+	assert.Empty(t, verifyIngressManifests(t, ingressV1Rules, "443"))
+}
+
+func TestIngressV1DefaultBackend(t *testing.T) {
+	assert.Empty(t, verifyIngressManifests(t, ingressV1DefaultBackend, "443"))
+	assert.Empty(t, verifyIngressManifests(t, ingressV1DefaultBackend, "https"))
+}
+
+func TestIngressV1WrongPort(t *testing.T) {
+	findings := verifyIngressManifests(t, ingressV1Rules, "8443")
+	assert.Equal(t, []string{"ingress-service-port-missing", "ingress-readiness-gate-missing"}, findingRules(findings))
+}
+
+func TestIngressV1beta1Backend(t *testing.T) {
+	ingress := `
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web-ingress
+  annotations:
+    alb.ingress.kubernetes.io/target-type: ip
+spec:
+  backend:
+    serviceName: web
+    servicePort: https
+`
+	assert.Empty(t, verifyIngressManifests(t, ingress, "https"))
+	assert.Empty(t, verifyIngressManifests(t, ingress, "443"))
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	argocdclient "github.com/argoproj/argo-cd/pkg/apiclient"
+	"github.com/argoproj/argo-cd/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/argo-cd/util"
+	"github.com/spf13/cobra"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// lastAppliedAnnotation is the annotation rewritten by the HPA guard
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// journalEntry records a mutation of the last-applied-configuration annotation of a live object
+type journalEntry struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	App        string    `json:"app"`
+	Group      string    `json:"group,omitempty"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name"`
+	APIVersion string    `json:"apiVersion"`
+	// OldAnnotation is empty if the object had no annotation before
+	OldAnnotation string `json:"oldAnnotation"`
+	NewAnnotation string `json:"newAnnotation"`
+	// UndoOf is the id of the entry reverted by this one
+	UndoOf string `json:"undoOf,omitempty"`
+}
+
+// defaultJournalPath is ~/.cd-guard/journal.jsonl, or no journal if there's no home directory
+func defaultJournalPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".cd-guard", "journal.jsonl")
+}
+
+// appendJournal adds the entry as a JSON line to the journal, the journal is created if it doesn't exist
+func appendJournal(path string, entry journalEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// readJournal returns the entries of the journal, the oldest first
+func readJournal(path string) ([]journalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries := make([]journalEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("not able to parse line %d of journal %s: %v", line, path, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// newJournalEntry returns an entry of the resource with a unique id
+func newJournalEntry(appName string, resource *argoappv1.ResourceDiff, namespace string, apiVersion string, oldAnnotation string, newAnnotation string) journalEntry {
+	now := time.Now().UTC()
+	return journalEntry{
+		ID:            strconv.FormatInt(now.UnixNano(), 36),
+		Time:          now,
+		App:           appName,
+		Group:         resource.Group,
+		Kind:          resource.Kind,
+		Namespace:     namespace,
+		Name:          resource.Name,
+		APIVersion:    apiVersion,
+		OldAnnotation: oldAnnotation,
+		NewAnnotation: newAnnotation,
+	}
+}
+
+// journalPatcher records every applied change of the last-applied-configuration annotation in the journal
+type journalPatcher struct {
+	patcher Patcher
+	journal string
+	appName string
+}
+
+func (p *journalPatcher) Patch(ctx context.Context, resource *argoappv1.ResourceDiff, namespace string, apiVersion string, patch []byte) error {
+	if err := p.patcher.Patch(ctx, resource, namespace, apiVersion, patch); err != nil {
+		return err
+	}
+	var patchObj struct {
+		Metadata struct {
+			Annotations map[string]*string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patch, &patchObj); err != nil {
+		return err
+	}
+	newAnnotation, ok := patchObj.Metadata.Annotations[lastAppliedAnnotation]
+	if !ok {
+		return nil
+	}
+	entry := newJournalEntry(p.appName, resource, namespace, apiVersion, "", "")
+	if newAnnotation != nil {
+		entry.NewAnnotation = *newAnnotation
+	}
+	if live, err := resource.LiveObject(); err == nil && live != nil {
+		entry.OldAnnotation = live.GetAnnotations()[lastAppliedAnnotation]
+	}
+	if err := appendJournal(p.journal, entry); err != nil {
+		return fmt.Errorf("%s:%s is patched, but not able to record it in journal %s: %v", resource.Kind, resource.Name, p.journal, err)
+	}
+	log.Infof("Patch of %s:%s recorded in journal %s as %s, revert it with `cd-guard undo %s`", resource.Kind, resource.Name, p.journal, entry.ID, entry.ID)
+	return nil
+}
+
+// entriesToUndo returns the entry with the given id, or for every resource of the application with the given name
+// the oldest patch which is not reverted yet, restoring the annotation it had before the guards patched it
+func entriesToUndo(entries []journalEntry, target string) ([]journalEntry, error) {
+	pending, keys, reverted := journalState(entries)
+	for _, entry := range entries {
+		if entry.ID == target {
+			if undo, ok := reverted[entry.ID]; ok {
+				return nil, fmt.Errorf("journal entry %s is already reverted by %s", entry.ID, undo)
+			}
+			return []journalEntry{entry}, nil
+		}
+	}
+
+	result := make([]journalEntry, 0)
+	found := false
+	for _, key := range keys {
+		if pending[key].app != target {
+			continue
+		}
+		found = true
+		if len(pending[key].entries) > 0 {
+			result = append(result, pending[key].entries[0])
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("there's no journal entry or application %s in the journal", target)
+	}
+	return result, nil
+}
+
+type resourcePatches struct {
+	app     string
+	entries []journalEntry
+}
+
+// journalState replays the journal and returns the patches of every resource which are not reverted yet, the resources
+// in the order they were first patched, and the id of the undo which reverted every other patch. An undo restores the
+// annotation from before the patch it reverts, so it reverts the later patches of the resource as well.
+func journalState(entries []journalEntry) (map[string]*resourcePatches, []string, map[string]string) {
+	pending := make(map[string]*resourcePatches)
+	keys := make([]string, 0)
+	reverted := make(map[string]string)
+	for _, entry := range entries {
+		key := entry.App + ":" + entry.Namespace + "/" + resourceKey(entry.Group, entry.Kind, entry.Name)
+		patches, ok := pending[key]
+		if !ok {
+			patches = &resourcePatches{app: entry.App}
+			pending[key] = patches
+			keys = append(keys, key)
+		}
+		if entry.UndoOf == "" {
+			patches.entries = append(patches.entries, entry)
+			continue
+		}
+		for i, patch := range patches.entries {
+			if patch.ID == entry.UndoOf {
+				for _, undone := range patches.entries[i:] {
+					reverted[undone.ID] = entry.ID
+				}
+				patches.entries = patches.entries[:i]
+				break
+			}
+		}
+	}
+	return pending, keys, reverted
+}
+
+// undoEntry restores the previous annotation of the entry through Argo CD and records the revert in the journal
+func undoEntry(ctx context.Context, appIf application.ApplicationServiceClient, journal string, entry journalEntry, dryRun bool) error {
+	var oldAnnotation interface{}
+	if entry.OldAnnotation != "" {
+		oldAnnotation = entry.OldAnnotation
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{lastAppliedAnnotation: oldAnnotation},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		log.Infof("DryRun on undo of %s, %s:%s of application %s won't be patched", entry.ID, entry.Kind, entry.Name, entry.App)
+		return nil
+	}
+
+	resource := &argoappv1.ResourceDiff{Group: entry.Group, Kind: entry.Kind, Namespace: entry.Namespace, Name: entry.Name}
+	patcher := &argoCDPatcher{appIf: appIf, appName: entry.App}
+	if err := patcher.Patch(ctx, resource, entry.Namespace, entry.APIVersion, patch); err != nil {
+		return fmt.Errorf("not able to undo %s on %s:%s: %v", entry.ID, entry.Kind, entry.Name, err)
+	}
+	log.Infof("Restored '%s' of %s:%s of application %s", lastAppliedAnnotation, entry.Kind, entry.Name, entry.App)
+
+	undo := newJournalEntry(entry.App, resource, entry.Namespace, entry.APIVersion, entry.NewAnnotation, entry.OldAnnotation)
+	undo.UndoOf = entry.ID
+	return appendJournal(journal, undo)
+}
+
+// NewUndoCommand returns a command which reverts the patches recorded in the journal
+func NewUndoCommand(o *GuardOptions) *cobra.Command {
+	var journal string
+	var dryRun bool
+	var timeout uint
+	var command = &cobra.Command{
+		Use:   "undo <journal-entry|app>",
+		Short: "Restore the last-applied-configuration annotation patched by a guard, of a journal entry or of all resources of an application",
+	}
+
+	command.Run = func(c *cobra.Command, args []string) {
+		target := appNameFromArgs(args)
+		if target == "" {
+			c.HelpFunc()(c, args)
+			os.Exit(exitCodeGuardError)
+		}
+		os.Exit(runUndo(o.clientOpts, target, journal, dryRun, timeout))
+	}
+	command.Flags().StringVar(&journal, "journal", defaultJournalPath(), "Journal of the patches")
+	command.Flags().BoolVar(&dryRun, "dryRun", false, "If true, it only tells which patches would be reverted")
+	command.Flags().UintVar(&timeout, "timeout", defaultCheckTimeoutSeconds, "Time out after this many seconds, 0 waits forever")
+	command.Flags().ParseErrorsWhitelist.UnknownFlags = true
+	command.PersistentFlags().ParseErrorsWhitelist.UnknownFlags = true
+	command.FParseErrWhitelist.UnknownFlags = true
+
+	return command
+}
+
+// runUndo reverts the journal entries of the target and returns the exit status of the command
+func runUndo(clientOpts *argocdclient.ClientOptions, target string, journal string, dryRun bool, timeout uint) int {
+	entries, err := readJournal(journal)
+	if err != nil {
+		log.Errorf("Not able to read journal: %v", err)
+		return exitCodeGuardError
+	}
+	toUndo, err := entriesToUndo(entries, target)
+	if err != nil {
+		log.Error(err)
+		return exitCodeGuardError
+	}
+	if len(toUndo) == 0 {
+		log.Infof("All patches of %s are already reverted", target)
+		return exitCodeOK
+	}
+
+	conn, appIf := newApplicationClient(clientOpts)
+	defer util.Close(conn)
+	ctx, cancel := withTimeout(context.Background(), timeout)
+	defer cancel()
+	return undoEntries(ctx, appIf, journal, toUndo, dryRun)
+}
+
+// undoEntries reverts every entry, a failing one doesn't stop the others
+func undoEntries(ctx context.Context, appIf application.ApplicationServiceClient, journal string, entries []journalEntry, dryRun bool) int {
+	code := exitCodeOK
+	for _, entry := range entries {
+		if err := undoEntry(ctx, appIf, journal, entry, dryRun); err != nil {
+			log.Error(err)
+			code = exitCodeGuardError
+		}
+	}
+	return code
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalAndUndo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cd-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "journal", "journal.jsonl")
+
+	name := resourceKey("apps", "Deployment", "hpa-samples-appd-deployment")
+	resourceDiff := toResourceDiff(t, deployDiff)
+	live, err := resourceDiff.LiveObject()
+	assert.Nil(t, err)
+	deployments := map[string]*scaleTarget{name: {resource: &resourceDiff, replicasPath: defaultReplicasPath}}
+
+	appIf := &fakeAppClient{}
+	opts := guardRunOptions{journal: journal}
+	patcher, dryRun := opts.patcher("hpa-samples", &argoCDPatcher{appIf: appIf, appName: "hpa-samples"})
+	assert.Nil(t, applyLastAppliedConfigPatch(context.Background(), patcher, []string{name}, deployments, dryRun))
+	assert.EqualValues(t, 1, len(appIf.patches))
+
+	entries, err := readJournal(journal)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(entries))
+	assert.EqualValues(t, "hpa-samples", entries[0].App)
+	assert.EqualValues(t, "hpa-samples-appd-deployment", entries[0].Name)
+	assert.EqualValues(t, live.GetAnnotations()[lastAppliedAnnotation], entries[0].OldAnnotation)
+	assert.NotEqual(t, entries[0].OldAnnotation, entries[0].NewAnnotation)
+
+	_, err = entriesToUndo(entries, "unknown")
+	assert.NotNil(t, err)
+	toUndo, err := entriesToUndo(entries, "hpa-samples")
+	assert.Nil(t, err)
+	assert.EqualValues(t, entries, toUndo)
+
+	assert.EqualValues(t, exitCodeOK, undoEntries(context.Background(), appIf, journal, toUndo, false))
+	assert.EqualValues(t, 2, len(appIf.patches))
+	assert.EqualValues(t, "hpa-samples-appd-deployment", appIf.patches[1].ResourceName)
+	assert.Contains(t, appIf.patches[1].Patch, "last-applied-configuration")
+
+	entries, err = readJournal(journal)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, len(entries))
+	assert.EqualValues(t, entries[0].ID, entries[1].UndoOf)
+	assert.EqualValues(t, entries[0].OldAnnotation, entries[1].NewAnnotation)
+
+	toUndo, err = entriesToUndo(entries, "hpa-samples")
+	assert.Nil(t, err)
+	assert.Empty(t, toUndo)
+	_, err = entriesToUndo(entries, entries[0].ID)
+	assert.EqualError(t, err, "journal entry "+entries[0].ID+" is already reverted by "+entries[1].ID)
+}
+
+func TestUndoRestoresOriginalAnnotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cd-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "journal.jsonl")
+
+	resource := &argoappv1.ResourceDiff{Group: "apps", Kind: "Deployment", Name: "web"}
+	first := newJournalEntry("web", resource, "default", "apps/v1", `{"replicas":3}`, `{}`)
+	first.ID = "first"
+	second := newJournalEntry("web", resource, "default", "apps/v1", `{}`, `{"image":"web:2"}`)
+	second.ID = "second"
+	assert.Nil(t, appendJournal(journal, first))
+	assert.Nil(t, appendJournal(journal, second))
+
+	entries, err := readJournal(journal)
+	assert.Nil(t, err)
+	toUndo, err := entriesToUndo(entries, "web")
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"first"}, []string{toUndo[0].ID})
+
+	appIf := &fakeAppClient{}
+	assert.EqualValues(t, exitCodeOK, undoEntries(context.Background(), appIf, journal, toUndo, false))
+	assert.EqualValues(t, 1, len(appIf.patches))
+	assert.Contains(t, appIf.patches[0].Patch, `{\"replicas\":3}`)
+
+	entries, err = readJournal(journal)
+	assert.Nil(t, err)
+	toUndo, err = entriesToUndo(entries, "web")
+	assert.Nil(t, err)
+	assert.Empty(t, toUndo)
+	_, err = entriesToUndo(entries, "second")
+	assert.NotNil(t, err)
+}
+
+func TestJournalOnlyWhenPatching(t *testing.T) {
+	opts := guardRunOptions{journal: "journal.jsonl", dryRun: true}
+	patcher, dryRun := opts.patcher("hpa-samples", &argoCDPatcher{appName: "hpa-samples"})
+	assert.IsType(t, &argoCDPatcher{}, patcher)
+	assert.True(t, dryRun)
+
+	opts = guardRunOptions{journal: "journal.jsonl"}
+	patcher, _ = opts.patcher("hpa-samples", &kubePatcher{})
+	assert.IsType(t, &kubePatcher{}, patcher)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+// kubeClient talks to the cluster selected by the kubeconfig flags directly instead of through Argo CD
+type kubeClient struct {
+	client dynamic.Interface
+	mapper meta.RESTMapper
+}
+
+// newKubeClient connects to the cluster of the kubeconfig context, the in-cluster config is used when no kubeconfig could be found
+func (o *GuardOptions) newKubeClient() (*kubeClient, error) {
+	restConfig, err := o.configFlags.ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("not able to load kubeconfig: %v", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	mapper, err := o.configFlags.ToRESTMapper()
+	if err != nil {
+		return nil, err
+	}
+	return &kubeClient{client: client, mapper: mapper}, nil
+}
+
+// resource returns the client of the kind, namespace is ignored for cluster scoped kinds
+func (c *kubeClient) resource(gvk schema.GroupVersionKind, namespace string) (dynamic.ResourceInterface, bool, error) {
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, false, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return c.client.Resource(mapping.Resource), false, nil
+	}
+	return c.client.Resource(mapping.Resource).Namespace(namespace), true, nil
+}
+
+// liveObjects reads the live objects of the desired manifests from the cluster.
+// The namespace is set on the namespaced manifests without one, objects which don't exist yet are skipped.
+func (c *kubeClient) liveObjects(targets []*unstructured.Unstructured, namespace string) ([]*unstructured.Unstructured, error) {
+	lives := make([]*unstructured.Unstructured, 0)
+	for _, target := range targets {
+		if target.GetNamespace() == "" {
+			target.SetNamespace(namespace)
+		}
+		resource, namespaced, err := c.resource(target.GroupVersionKind(), target.GetNamespace())
+		if err != nil {
+			return nil, fmt.Errorf("not able to find the resource of %s: %v", target.GroupVersionKind().String(), err)
+		}
+		if !namespaced {
+			target.SetNamespace("")
+		}
+		live, err := resource.Get(target.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not able to get %s %s: %v", target.GetKind(), target.GetName(), err)
+		}
+		lives = append(lives, live)
+	}
+	return lives, nil
+}
+
+// kubePatcher patches the live objects in the cluster directly
+type kubePatcher struct {
+	kube *kubeClient
+}
+
+func (p *kubePatcher) Patch(ctx context.Context, resource *argoappv1.ResourceDiff, namespace string, apiVersion string, patch []byte) error {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return err
+	}
+	client, _, err := p.kube.resource(gv.WithKind(resource.Kind), namespace)
+	if err != nil {
+		return err
+	}
+	_, err = client.Patch(resource.Name, types.MergePatchType, patch, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func newTestKubeClient(t *testing.T, manifests ...string) *kubeClient {
+	objects := make([]runtime.Object, 0)
+	for _, manifest := range manifests {
+		objects = append(objects, toUnstructured(t, manifest))
+	}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "HorizontalPodAutoscaler"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	return &kubeClient{client: fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...), mapper: mapper}
+}
+
+func TestKubeLiveObjects(t *testing.T) {
+	kube := newTestKubeClient(t, webhookDeployment)
+	targets, err := decodeManifests(strings.NewReader(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 3
+---
+apiVersion: autoscaling/v1
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+spec:
+  maxReplicas: 10
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: web-qal
+`), "test")
+	assert.Nil(t, err)
+
+	lives, err := kube.liveObjects(targets, "web-qal")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(lives))
+	assert.Equal(t, "web", lives[0].GetName())
+	assert.Equal(t, "web-qal", targets[1].GetNamespace())
+	assert.Equal(t, "", targets[2].GetNamespace())
+
+	resourceDiffs, err := manifestResourceDiffs(targets, lives)
+	assert.Nil(t, err)
+	_, _, _, findings := verifyHpa(resourceDiffs)
+	assert.Equal(t, []string{"hpa-replicas-set"}, findingRules(findings))
+
+	_, err = kube.liveObjects([]*unstructured.Unstructured{toUnstructured(t, `
+apiVersion: example.com/v1
+kind: Worker
+metadata:
+  name: web
+`)}, "web-qal")
+	assert.NotNil(t, err)
+}
+
+func TestKubePatcher(t *testing.T) {
+	kube := newTestKubeClient(t, webhookDeployment)
+	fakeClient := kube.client.(*fake.FakeDynamicClient)
+	fakeClient.PrependReactor("patch", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, toUnstructured(t, webhookDeployment), nil
+	})
+	resourceDiffs := manifestsToResourceDiffs(t, webhookDeployment)
+
+	patcher := &kubePatcher{kube: kube}
+	patch := []byte(`{"metadata":{"annotations":{"patched":"true"}}}`)
+	assert.Nil(t, patcher.Patch(nil, resourceDiffs[0], "web-qal", "apps/v1", patch))
+
+	actions := fakeClient.Actions()
+	assert.Equal(t, 1, len(actions))
+	patchAction := actions[0].(clienttesting.PatchAction)
+	assert.Equal(t, "web-qal", patchAction.GetNamespace())
+	assert.Equal(t, "web", patchAction.GetName())
+	assert.Equal(t, patch, patchAction.GetPatch())
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+// loadManifests reads the YAML or JSON manifests from a file, all files of a directory, or stdin when path is "-"
+func loadManifests(path string) ([]*unstructured.Unstructured, error) {
+	if path == "-" {
+		return decodeManifests(os.Stdin, "stdin")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return loadManifestFile(path)
+	}
+
+	objs := make([]*unstructured.Unstructured, 0)
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".yaml", ".yml", ".json":
+			fileObjs, err := loadManifestFile(file)
+			if err != nil {
+				return err
+			}
+			objs = append(objs, fileObjs...)
+		}
+		return nil
+	})
+	return objs, err
+}
+
+func loadManifestFile(path string) ([]*unstructured.Unstructured, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeManifests(f, path)
+}
+
+// decodeManifests decodes all documents of a YAML stream or JSON objects, "List" kinds are expanded to their items
+func decodeManifests(r io.Reader, source string) ([]*unstructured.Unstructured, error) {
+	objs := make([]*unstructured.Unstructured, 0)
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not able to parse manifests in %s: %v", source, err)
+		}
+		if len(obj.Object) == 0 { //Empty document
+			continue
+		}
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("manifest in %s has no 'kind' or 'apiVersion'", source)
+		}
+		if obj.IsList() {
+			err = obj.EachListItem(func(item runtime.Object) error {
+				objs = append(objs, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("not able to parse list in %s: %v", source, err)
+			}
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// manifestResourceDiffs pairs the target objects with the live objects the same way Argo CD does for ManagedResources.
+// Live objects without target object are kept as they would be pruned.
+func manifestResourceDiffs(targets []*unstructured.Unstructured, lives []*unstructured.Unstructured) ([]*argoappv1.ResourceDiff, error) {
+	liveByKey := make(map[string]*unstructured.Unstructured)
+	for _, live := range lives {
+		liveByKey[manifestKey(live, live.GetNamespace())] = live
+	}
+
+	resourceDiffs := make([]*argoappv1.ResourceDiff, 0)
+	for _, target := range targets {
+		key := manifestKey(target, target.GetNamespace())
+		live := liveByKey[key]
+		if live == nil && target.GetNamespace() == "" { //The namespace is decided at deploy time, match the live object by name
+			for liveKey, obj := range liveByKey {
+				if manifestKey(obj, "") == key {
+					key = liveKey
+					live = obj
+					break
+				}
+			}
+		}
+		delete(liveByKey, key)
+
+		resourceDiff, err := newManifestResourceDiff(target, live)
+		if err != nil {
+			return nil, err
+		}
+		resourceDiffs = append(resourceDiffs, resourceDiff)
+	}
+
+	liveKeys := make([]string, 0, len(liveByKey))
+	for key := range liveByKey {
+		liveKeys = append(liveKeys, key)
+	}
+	sort.Strings(liveKeys)
+	for _, key := range liveKeys {
+		resourceDiff, err := newManifestResourceDiff(nil, liveByKey[key])
+		if err != nil {
+			return nil, err
+		}
+		resourceDiffs = append(resourceDiffs, resourceDiff)
+	}
+	return resourceDiffs, nil
+}
+
+func manifestKey(obj *unstructured.Unstructured, namespace string) string {
+	gvk := obj.GroupVersionKind()
+	return fmt.Sprintf("%s/%s/%s/%s", gvk.Group, gvk.Kind, namespace, obj.GetName())
+}
+
+func newManifestResourceDiff(target *unstructured.Unstructured, live *unstructured.Unstructured) (*argoappv1.ResourceDiff, error) {
+	obj := target
+	if obj == nil {
+		obj = live
+	}
+	resourceDiff := &argoappv1.ResourceDiff{
+		Group:     obj.GroupVersionKind().Group,
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+	if resourceDiff.Namespace == "" && live != nil {
+		resourceDiff.Namespace = live.GetNamespace()
+	}
+	if target != nil {
+		bytes, err := json.Marshal(target.Object)
+		if err != nil {
+			return nil, err
+		}
+		resourceDiff.TargetState = string(bytes)
+	}
+	if live != nil {
+		bytes, err := json.Marshal(live.Object)
+		if err != nil {
+			return nil, err
+		}
+		resourceDiff.LiveState = string(bytes)
+	}
+	return resourceDiff, nil
+}
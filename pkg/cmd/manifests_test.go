@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const hpaManifests = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: hpa-samples
+  namespace: hpa-samples-qal
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: hpa-samples
+  template:
+    metadata:
+      labels:
+        app: hpa-samples
+    spec:
+      containers:
+      - name: app
+        image: hpa-samples:latest
+---
+# An empty document
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: autoscaling/v2beta1
+  kind: HorizontalPodAutoscaler
+  metadata:
+    name: hpa-samples-hpa
+    namespace: hpa-samples-qal
+  spec:
+    minReplicas: 2
+    maxReplicas: 5
+    scaleTargetRef:
+      apiVersion: apps/v1
+      kind: Deployment
+      name: hpa-samples
+`
+
+const hpaLiveManifests = `{
+  "apiVersion": "apps/v1",
+  "kind": "Deployment",
+  "metadata": {"name": "hpa-samples", "namespace": "hpa-samples-qal"},
+  "spec": {"replicas": 3}
+}
+{
+  "apiVersion": "v1",
+  "kind": "ConfigMap",
+  "metadata": {"name": "orphan", "namespace": "hpa-samples-qal"}
+}`
+
+func TestDecodeManifests(t *testing.T) {
+	objs, err := decodeManifests(strings.NewReader(hpaManifests), "test")
+	assert.Nil(t, err)
+	assert.EqualValues(t, len(objs), 2)
+	assert.EqualValues(t, objs[0].GetKind(), "Deployment")
+	assert.EqualValues(t, objs[1].GetKind(), "HorizontalPodAutoscaler")
+
+	_, err = decodeManifests(strings.NewReader("metadata:\n  name: no-kind\n"), "test")
+	assert.NotNil(t, err)
+}
+
+func TestManifestResourceDiffs(t *testing.T) {
+	targets, err := decodeManifests(strings.NewReader(hpaManifests), "test")
+	assert.Nil(t, err)
+	lives, err := decodeManifests(strings.NewReader(hpaLiveManifests), "test")
+	assert.Nil(t, err)
+
+	diffs, err := manifestResourceDiffs(targets, lives)
+	assert.Nil(t, err)
+	assert.EqualValues(t, len(diffs), 3)
+
+	assert.EqualValues(t, diffs[0].Group, "apps")
+	assert.NotEmpty(t, diffs[0].TargetState)
+	assert.NotEmpty(t, diffs[0].LiveState)
+
+	assert.EqualValues(t, diffs[1].Group, "autoscaling")
+	assert.Empty(t, diffs[1].LiveState)
+
+	assert.EqualValues(t, diffs[2].Kind, "ConfigMap")
+	assert.Empty(t, diffs[2].TargetState)
+}
+
+func TestGuardManifestsDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cd-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "app.yaml"), []byte(hpaManifests), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("not a manifest"), 0644))
+
+	objs, err := loadManifests(dir)
+	assert.Nil(t, err)
+	diffs, err := manifestResourceDiffs(objs, nil)
+	assert.Nil(t, err)
+
+	_, _, _, findings := verifyHpa(diffs)
+	assert.EqualValues(t, len(findings), 1)
+	assert.EqualValues(t, findings[0].Rule, "hpa-replicas-set")
+
+	assert.EqualValues(t, runGuardsOnManifests("", Guards(), guardRunOptions{manifests: dir, config: &GuardConfig{}}), exitCodeViolations)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register(&namespaceGuard{})
+}
+
+// namespaceGuard makes sure the objects land in the destination namespace of the Argo CD Application
+// and the cluster-scoped objects are allowed by its project
+type namespaceGuard struct{}
+
+func (g *namespaceGuard) Name() string {
+	return "namespace"
+}
+
+func (g *namespaceGuard) Description() string {
+	return "Check namespaces against the application destination and project"
+}
+
+func (g *namespaceGuard) Evaluate(app *GuardContext) ([]Finding, error) {
+	if app.Application == nil {
+		log.Infof("No Argo CD application, no destination namespace to check")
+		return nil, nil
+	}
+	return verifyNamespaces(app.Resources, app.Application.Spec.Destination, app.Project), nil
+}
+
+// clusterScopedKinds are the built-in and well-known cluster-scoped kinds by resourceKey without name
+var clusterScopedKinds = map[string]bool{
+	resourceKey("", "Namespace", ""):                                                  true,
+	resourceKey("", "Node", ""):                                                       true,
+	resourceKey("", "PersistentVolume", ""):                                           true,
+	resourceKey("rbac.authorization.k8s.io", "ClusterRole", ""):                       true,
+	resourceKey("rbac.authorization.k8s.io", "ClusterRoleBinding", ""):                true,
+	resourceKey("storage.k8s.io", "StorageClass", ""):                                 true,
+	resourceKey("storage.k8s.io", "CSIDriver", ""):                                    true,
+	resourceKey("storage.k8s.io", "VolumeAttachment", ""):                             true,
+	resourceKey("apiextensions.k8s.io", "CustomResourceDefinition", ""):               true,
+	resourceKey("apiregistration.k8s.io", "APIService", ""):                           true,
+	resourceKey("admissionregistration.k8s.io", "MutatingWebhookConfiguration", ""):   true,
+	resourceKey("admissionregistration.k8s.io", "ValidatingWebhookConfiguration", ""): true,
+	resourceKey("scheduling.k8s.io", "PriorityClass", ""):                             true,
+	resourceKey("policy", "PodSecurityPolicy", ""):                                    true,
+	resourceKey("extensions", "PodSecurityPolicy", ""):                                true,
+	resourceKey("networking.k8s.io", "IngressClass", ""):                              true,
+	resourceKey("node.k8s.io", "RuntimeClass", ""):                                    true,
+	resourceKey("certificates.k8s.io", "CertificateSigningRequest", ""):               true,
+	resourceKey("argoproj.io", "ClusterAnalysisTemplate", ""):                         true,
+	resourceKey("argoproj.io", "ClusterWorkflowTemplate", ""):                         true,
+	resourceKey("cert-manager.io", "ClusterIssuer", ""):                               true,
+}
+
+func verifyNamespaces(resourceDiffs []*argoappv1.ResourceDiff, destination argoappv1.ApplicationDestination, project *argoappv1.AppProject) []Finding {
+	findings := make([]Finding, 0)
+	customScopes := customResourceScopes(resourceDiffs)
+	for _, resource := range resourceDiffs {
+		target, err := resource.TargetObject()
+		if err != nil || target == nil {
+			continue
+		}
+		if isClusterScoped(resource, customScopes) {
+			groupKind := metav1.GroupKind{Group: resource.Group, Kind: resource.Kind}
+			if project != nil && !project.IsResourcePermitted(groupKind, false) {
+				findings = append(findings, newResourceFinding("namespace-cluster-resource-denied", SeverityError, resource,
+					fmt.Sprintf("%s:%s is cluster-scoped, but the project %s doesn't allow %s", resource.Kind, resource.Name, project.Name, groupKindString(groupKind)),
+					fmt.Sprintf("Add %s to 'spec.clusterResourceWhitelist' of the project or move it to an application of another project", groupKindString(groupKind))))
+			}
+			continue
+		}
+
+		namespace := target.GetNamespace()
+		if namespace == "" {
+			if destination.Namespace == "" {
+				findings = append(findings, newResourceFinding("namespace-missing", SeverityError, resource,
+					fmt.Sprintf("%s:%s has no namespace and the application has no destination namespace, it lands in the namespace of the cluster connection, usually 'default'", resource.Kind, resource.Name),
+					"Set 'metadata.namespace' of the object or 'spec.destination.namespace' of the application"))
+			}
+			continue
+		}
+		if namespace == destination.Namespace || (destination.Namespace == "" && project == nil) {
+			continue
+		}
+		if project != nil && !project.IsDestinationPermitted(argoappv1.ApplicationDestination{Server: destination.Server, Namespace: namespace}) {
+			findings = append(findings, newResourceFinding("namespace-destination-denied", SeverityError, resource,
+				fmt.Sprintf("%s:%s targets the namespace %s which is not a destination of the project %s", resource.Kind, resource.Name, namespace, project.Name),
+				fmt.Sprintf("Deploy it to the destination namespace %s or add the namespace to 'spec.destinations' of the project", destination.Namespace)))
+			continue
+		}
+		if destination.Namespace != "" {
+			findings = append(findings, newResourceFinding("namespace-mismatch", SeverityWarning, resource,
+				fmt.Sprintf("%s:%s targets the namespace %s, but the application is deployed to %s", resource.Kind, resource.Name, namespace, destination.Namespace),
+				"Drop 'metadata.namespace' so the object follows the destination namespace, or move it to its own application"))
+		}
+	}
+	return findings
+}
+
+// isClusterScoped tells whether the resource is cluster-scoped by its kind, by the CRD of the application
+// or by the live object which has no namespace
+func isClusterScoped(resource *argoappv1.ResourceDiff, customScopes map[string]bool) bool {
+	key := resourceKey(resource.Group, resource.Kind, "")
+	if clusterScopedKinds[key] {
+		return true
+	}
+	if clusterScoped, ok := customScopes[key]; ok {
+		return clusterScoped
+	}
+	live, err := resource.LiveObject()
+	return err == nil && live != nil && live.GetNamespace() == ""
+}
+
+// customResourceScopes returns whether the custom resources of the CRDs in the application are cluster-scoped by resourceKey without name
+func customResourceScopes(resourceDiffs []*argoappv1.ResourceDiff) map[string]bool {
+	scopes := make(map[string]bool)
+	for _, crd := range targetObjectsOfKind(resourceDiffs, "apiextensions.k8s.io", "CustomResourceDefinition") {
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+		scope, _, _ := unstructured.NestedString(crd.Object, "spec", "scope")
+		scopes[resourceKey(group, kind, "")] = scope == "Cluster"
+	}
+	return scopes
+}
+
+// groupKindString describes the group and kind like the project settings do, e.g. "rbac.authorization.k8s.io/ClusterRole"
+func groupKindString(groupKind metav1.GroupKind) string {
+	if groupKind.Group == "" {
+		return groupKind.Kind
+	}
+	return groupKind.Group + "/" + groupKind.Kind
+}
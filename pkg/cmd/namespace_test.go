@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+const namespaceConfigMap = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  namespace: web-prd
+`
+
+const namespaceClusterRole = `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: web
+`
+
+func namespaceDestination(namespace string) argoappv1.ApplicationDestination {
+	return argoappv1.ApplicationDestination{Server: "https://kubernetes.default.svc", Namespace: namespace}
+}
+
+func namespaceProject(destinations ...string) *argoappv1.AppProject {
+	project := &argoappv1.AppProject{}
+	project.Name = "web"
+	for _, namespace := range destinations {
+		project.Spec.Destinations = append(project.Spec.Destinations, namespaceDestination(namespace))
+	}
+	return project
+}
+
+func TestNamespaceMatchesDestination(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, namespaceConfigMap, pdbDeployment)
+	assert.Empty(t, verifyNamespaces(diffs, namespaceDestination("web-prd"), namespaceProject("web-prd")))
+	assert.Empty(t, verifyNamespaces(diffs, namespaceDestination("web-prd"), nil))
+}
+
+func TestNamespaceMismatch(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, namespaceConfigMap)
+	findings := verifyNamespaces(diffs, namespaceDestination("web-qal"), nil)
+	assert.Equal(t, []string{"namespace-mismatch"}, findingRules(findings))
+	assert.Equal(t, SeverityWarning, findings[0].Severity)
+
+	findings = verifyNamespaces(diffs, namespaceDestination("web-qal"), namespaceProject("web-*"))
+	assert.Equal(t, []string{"namespace-mismatch"}, findingRules(findings))
+
+	findings = verifyNamespaces(diffs, namespaceDestination("web-qal"), namespaceProject("web-qal"))
+	assert.Equal(t, []string{"namespace-destination-denied"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "project web")
+}
+
+func TestNamespaceMissing(t *testing.T) {
+	configMap := `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+`
+	diffs := manifestsToResourceDiffs(t, configMap, namespaceClusterRole)
+	assert.Empty(t, verifyNamespaces(diffs, namespaceDestination("web-prd"), nil))
+
+	findings := verifyNamespaces(diffs, namespaceDestination(""), nil)
+	assert.Equal(t, []string{"namespace-missing"}, findingRules(findings))
+	assert.Equal(t, "ConfigMap", findings[0].Kind)
+}
+
+func TestNamespaceClusterResources(t *testing.T) {
+	crd := `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: tenants.example.com
+spec:
+  group: example.com
+  names:
+    kind: Tenant
+    plural: tenants
+  scope: Cluster
+`
+	tenant := `
+apiVersion: example.com/v1
+kind: Tenant
+metadata:
+  name: web
+`
+	diffs := manifestsToResourceDiffs(t, namespaceClusterRole, crd, tenant)
+	assert.Empty(t, verifyNamespaces(diffs, namespaceDestination(""), nil))
+
+	project := namespaceProject("web-prd")
+	project.Spec.ClusterResourceWhitelist = []metav1.GroupKind{{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"}}
+	findings := verifyNamespaces(diffs, namespaceDestination("web-prd"), project)
+	assert.Equal(t, []string{"namespace-cluster-resource-denied", "namespace-cluster-resource-denied"}, findingRules(findings))
+	assert.Equal(t, "CustomResourceDefinition", findings[0].Kind)
+	assert.Contains(t, findings[1].Fix, "example.com/Tenant")
+}
+
+func TestNamespaceGuardWithoutApplication(t *testing.T) {
+	findings, err := (&namespaceGuard{}).Evaluate(&GuardContext{Resources: manifestsToResourceDiffs(t, namespaceConfigMap)})
+	assert.Nil(t, err)
+	assert.Empty(t, findings)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+// patchWriter writes the patches to a directory for review instead of applying them.
+// Every patch is written as JSON merge patch together with a script applying it by argocd or kubectl.
+type patchWriter struct {
+	dir     string
+	appName string
+	// argoCD applies the patch through the Argo CD application instead of kubectl
+	argoCD bool
+}
+
+func (w *patchWriter) Patch(ctx context.Context, resource *argoappv1.ResourceDiff, namespace string, apiVersion string, patch []byte) error {
+	dir := w.dir
+	if w.appName != "" {
+		dir = filepath.Join(dir, w.appName)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, patch, "", "  "); err != nil {
+		return err
+	}
+	pretty.WriteString("\n")
+
+	name := patchFileName(resource, namespace)
+	patchFile := filepath.Join(dir, name+".json")
+	if err := ioutil.WriteFile(patchFile, pretty.Bytes(), 0644); err != nil {
+		return err
+	}
+	script := "#!/bin/sh\nset -e\ncd \"$(dirname \"$0\")\"\n" + w.command(resource, namespace, apiVersion, name+".json") + "\n"
+	scriptFile := filepath.Join(dir, name+".sh")
+	if err := ioutil.WriteFile(scriptFile, []byte(script), 0755); err != nil {
+		return err
+	}
+	log.Infof("Patch of %s:%s written to %s, apply it with %s", resource.Kind, resource.Name, patchFile, scriptFile)
+	return nil
+}
+
+// command returns the shell command applying the patch file
+func (w *patchWriter) command(resource *argoappv1.ResourceDiff, namespace string, apiVersion string, patchFile string) string {
+	args := make([]string, 0)
+	if w.argoCD {
+		args = append(args, "argocd", "app", "patch-resource", shellQuote(w.appName),
+			"--kind", shellQuote(resource.Kind),
+			"--resource-name", shellQuote(resource.Name))
+		if resource.Group != "" {
+			args = append(args, "--group", shellQuote(resource.Group))
+		}
+		if namespace != "" {
+			args = append(args, "--namespace", shellQuote(namespace))
+		}
+		args = append(args, "--patch-type", "application/merge-patch+json")
+	} else {
+		args = append(args, "kubectl", "patch", shellQuote(kubectlResource(resource.Kind, apiVersion)), shellQuote(resource.Name))
+		if namespace != "" {
+			args = append(args, "--namespace", shellQuote(namespace))
+		}
+		args = append(args, "--type", "merge")
+	}
+	args = append(args, "--patch", fmt.Sprintf(`"$(cat %s)"`, shellQuote(patchFile)))
+	return strings.Join(args, " ")
+}
+
+// patchFileName names the patch files of a resource by kind, namespace and name
+func patchFileName(resource *argoappv1.ResourceDiff, namespace string) string {
+	parts := []string{strings.ToLower(resource.Kind)}
+	if namespace != "" {
+		parts = append(parts, namespace)
+	}
+	parts = append(parts, resource.Name)
+	return strings.Join(parts, "-")
+}
+
+// kubectlResource returns the fully qualified resource of kubectl, e.g. "deployment.v1.apps"
+func kubectlResource(kind string, apiVersion string) string {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil || gv.Group == "" {
+		return strings.ToLower(kind)
+	}
+	return strings.Join([]string{strings.ToLower(kind), gv.Version, gv.Group}, ".")
+}
+
+// shellQuote quotes the value for the shell unless it is safe as it is
+func shellQuote(value string) string {
+	if value != "" && strings.Trim(value, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.:/=+") == "" {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'"'"'`, -1) + "'"
+}
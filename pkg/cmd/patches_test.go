@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+func TestEmitPatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cd-guard")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	name := resourceKey("apps", "Deployment", "hpa-samples-appd-deployment")
+	resourceDiff := toResourceDiff(t, deployDiff)
+	deployments := map[string]*scaleTarget{name: {resource: &resourceDiff, replicasPath: defaultReplicasPath}}
+
+	opts := guardRunOptions{emitPatches: dir}
+	patcher, dryRun := opts.patcher("hpa-samples", &argoCDPatcher{appName: "hpa-samples"})
+	assert.False(t, dryRun)
+	assert.Nil(t, applyLastAppliedConfigPatch(nil, patcher, []string{name}, deployments, dryRun))
+
+	base := filepath.Join(dir, "hpa-samples", "deployment-dev-containers-hpa-samples-usw2-ppd-qal-hpa-samples-appd-deployment")
+	patch, err := ioutil.ReadFile(base + ".json")
+	assert.Nil(t, err)
+	assert.Contains(t, string(patch), `"kubectl.kubernetes.io/last-applied-configuration"`)
+	assert.NotContains(t, string(patch), `\"replicas\"`)
+
+	script, err := ioutil.ReadFile(base + ".sh")
+	assert.Nil(t, err)
+	assert.Contains(t, string(script), "argocd app patch-resource hpa-samples --kind Deployment --resource-name hpa-samples-appd-deployment --group apps")
+	assert.Contains(t, string(script), `--patch-type application/merge-patch+json --patch "$(cat deployment-dev-containers-hpa-samples-usw2-ppd-qal-hpa-samples-appd-deployment.json)"`)
+}
+
+func TestEmitPatchesKubectl(t *testing.T) {
+	writer := &patchWriter{dir: "patches"}
+	assert.EqualValues(t, "kubectl patch deployment.v1.apps web --namespace web-qal --type merge --patch \"$(cat 'web patch.json')\"",
+		writer.command(&argoappv1.ResourceDiff{Group: "apps", Kind: "Deployment", Name: "web"}, "web-qal", "apps/v1", "web patch.json"))
+	assert.EqualValues(t, "service", kubectlResource("Service", "v1"))
+
+	opts := guardRunOptions{dryRun: true}
+	patcher, dryRun := opts.patcher("", nil)
+	assert.Nil(t, patcher)
+	assert.True(t, dryRun)
+	opts = guardRunOptions{emitPatches: "patches"}
+	patcher, dryRun = opts.patcher("", nil)
+	assert.EqualValues(t, &patchWriter{dir: "patches"}, patcher)
+	assert.False(t, dryRun)
+}
@@ -0,0 +1,304 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register(&pdbGuard{})
+}
+
+// pdbGuard makes sure the Deployments and Rollouts in production are protected by a PodDisruptionBudget which allows eviction
+type pdbGuard struct{}
+
+func (g *pdbGuard) Name() string {
+	return "pdb"
+}
+
+func (g *pdbGuard) Description() string {
+	return "Check PodDisruptionBudget of Deployment and Rollout"
+}
+
+func (g *pdbGuard) Evaluate(app *GuardContext) ([]Finding, error) {
+	return verifyPdb(app.Resources, app.Production), nil
+}
+
+// podDisruptionBudget is a PDB of the application with its parsed selector
+type podDisruptionBudget struct {
+	obj      *unstructured.Unstructured
+	selector labels.Selector
+	selected []workload
+}
+
+func verifyPdb(resourceDiffs []*argoappv1.ResourceDiff, production bool) []Finding {
+	findings := make([]Finding, 0)
+	pdbs := make([]*podDisruptionBudget, 0)
+	for _, obj := range targetObjectsOfKind(resourceDiffs, "policy", "PodDisruptionBudget") {
+		selector, err := labelSelector(obj, "spec", "selector")
+		if err != nil {
+			findings = append(findings, newFinding("pdb-selector-invalid", SeverityError, obj,
+				fmt.Sprintf("The PodDisruptionBudget %s has an invalid selector: %v", obj.GetName(), err), ""))
+			continue
+		}
+		// An empty selector selects every pod of the namespace in policy/v1, and none in policy/v1beta1
+		if selector.Empty() && obj.GetAPIVersion() == "policy/v1beta1" {
+			selector = labels.Nothing()
+		}
+		pdbs = append(pdbs, &podDisruptionBudget{obj: obj, selector: selector})
+	}
+
+	hpaRanges := hpaReplicaRanges(resourceDiffs)
+	for _, w := range workloads(resourceDiffs) {
+		template, err := podTemplate(w.obj)
+		if err != nil {
+			findings = append(findings, newResourceFinding("pdb-workload-invalid", SeverityWarning, w.resource,
+				fmt.Sprintf("The pod template has error %v", err), ""))
+			continue
+		}
+		replicas, maxReplicas, source := workloadReplicas(w, hpaRanges)
+		update, err := resolveRollingUpdate(w, replicas)
+		if err != nil {
+			findings = append(findings, newResourceFinding("pdb-workload-invalid", SeverityWarning, w.resource,
+				fmt.Sprintf("The update strategy has error %v", err), ""))
+			continue
+		}
+		if update.stalls {
+			findings = append(findings, newResourceFinding("pdb-rollout-stalls", SeverityError, w.resource,
+				fmt.Sprintf("The rolling update of %s:%s has maxSurge and maxUnavailable 0, it could never replace a pod", w.resource.Kind, w.resource.Name),
+				"Set 'maxSurge' or 'maxUnavailable' of the rolling update to at least 1"))
+		}
+
+		matched := make([]*podDisruptionBudget, 0)
+		for _, pdb := range pdbs {
+			if sameNamespace(pdb.obj, w.obj) && pdb.selector.Matches(labels.Set(template.Labels)) {
+				pdb.selected = append(pdb.selected, w)
+				matched = append(matched, pdb)
+			}
+		}
+
+		if len(matched) == 0 {
+			if production {
+				findings = append(findings, newResourceFinding("pdb-missing", SeverityError, w.resource,
+					fmt.Sprintf("%s:%s is deployed to production, but no PodDisruptionBudget selects its pods", w.resource.Kind, w.resource.Name),
+					"Add a PodDisruptionBudget whose 'spec.selector' matches the pod template labels"))
+			}
+			continue
+		}
+		if len(matched) > 1 {
+			names := make([]string, 0)
+			for _, pdb := range matched {
+				names = append(names, pdb.obj.GetName())
+			}
+			findings = append(findings, newResourceFinding("pdb-multiple", SeverityWarning, w.resource,
+				fmt.Sprintf("The pods of %s:%s are selected by more than one PodDisruptionBudget (%s), the eviction API refuses to evict them", w.resource.Kind, w.resource.Name, strings.Join(names, ", ")),
+				"Keep only one PodDisruptionBudget per workload"))
+		}
+
+		for _, pdb := range matched {
+			allowed, err := allowedDisruptions(pdb.obj, replicas)
+			if err != nil {
+				findings = append(findings, newFinding("pdb-budget-invalid", SeverityError, pdb.obj,
+					fmt.Sprintf("The PodDisruptionBudget %s has an invalid budget: %v", pdb.obj.GetName(), err), ""))
+				continue
+			}
+			// The allowed disruptions never decrease with more replicas, the lowest replicas are the worst case
+			if allowed <= 0 && replicas > 0 {
+				message := fmt.Sprintf("The PodDisruptionBudget %s allows no eviction of %s:%s with %d replicas (%s)", pdb.obj.GetName(), w.resource.Kind, w.resource.Name, replicas, source)
+				if maxAllowed, err := allowedDisruptions(pdb.obj, maxReplicas); err == nil && maxAllowed <= 0 && maxReplicas > replicas {
+					message = fmt.Sprintf("%s, not even with %d replicas (HPA maxReplicas), node drains could never make progress", message, maxReplicas)
+				}
+				findings = append(findings, newFinding("pdb-blocks-eviction", SeverityError, pdb.obj, message,
+					"Lower 'minAvailable' below the replicas or set 'maxUnavailable' to at least 1"))
+				continue
+			}
+			if update.unavailable > allowed {
+				findings = append(findings, newFinding("pdb-rollout-disrupts", SeverityWarning, pdb.obj,
+					fmt.Sprintf("The %s of %s:%s takes down up to %d of %d pods (%s) while the PodDisruptionBudget %s allows %d, node drains are blocked until the rollout finishes",
+						update.strategy, w.resource.Kind, w.resource.Name, update.unavailable, replicas, source, pdb.obj.GetName(), allowed),
+					"Lower 'maxUnavailable' of the update strategy and raise 'maxSurge' instead, or allow more disruptions"))
+			}
+		}
+	}
+
+	for _, pdb := range pdbs {
+		if len(pdb.selected) == 0 {
+			findings = append(findings, newFinding("pdb-selects-nothing", SeverityError, pdb.obj,
+				fmt.Sprintf("The PodDisruptionBudget %s doesn't select the pods of any Deployment or Rollout in the application", pdb.obj.GetName()),
+				"Make 'spec.selector' match the pod template labels of a workload"))
+		}
+	}
+
+	if len(findings) == 0 {
+		log.Infof("PodDisruptionBudgets are good to pass through")
+	}
+	return findings
+}
+
+// replicaRange is the lowest and the highest number of replicas of a workload
+type replicaRange struct {
+	min int64
+	max int64
+}
+
+// hpaReplicaRanges returns the HPA 'spec.minReplicas' and 'spec.maxReplicas' of the scaled objects by resourceKey
+func hpaReplicaRanges(resourceDiffs []*argoappv1.ResourceDiff) map[string]replicaRange {
+	result := make(map[string]replicaRange)
+	hpas, resourceNames, _ := hpaReferencesObjects(resourceDiffs)
+	for i, hpa := range hpas {
+		minReplicas, found, err := unstructured.NestedInt64(hpa.Object, "spec", "minReplicas")
+		if err != nil || !found {
+			minReplicas = 1
+		}
+		maxReplicas, found, err := unstructured.NestedInt64(hpa.Object, "spec", "maxReplicas")
+		if err != nil || !found || maxReplicas < minReplicas {
+			maxReplicas = minReplicas
+		}
+		result[resourceNames[i]] = replicaRange{min: minReplicas, max: maxReplicas}
+	}
+	return result
+}
+
+// workloadReplicas returns the lowest and the highest number of replicas of the workload and where the lowest comes from
+func workloadReplicas(w workload, hpaRanges map[string]replicaRange) (int64, int64, string) {
+	if replicas, ok := hpaRanges[resourceKey(w.resource.Group, w.resource.Kind, w.resource.Name)]; ok {
+		return replicas.min, replicas.max, "HPA minReplicas"
+	}
+	replicas, found, err := unstructured.NestedInt64(w.obj.Object, "spec", "replicas")
+	if err != nil || !found {
+		return 1, 1, "default replicas"
+	}
+	return replicas, replicas, "spec.replicas"
+}
+
+// rollingUpdate is the update strategy of a workload resolved for a number of replicas
+type rollingUpdate struct {
+	strategy string
+	// unavailable is how many pods the update takes down at once
+	unavailable int64
+	// stalls is true if the update could never replace a pod
+	stalls bool
+}
+
+// resolveRollingUpdate works out the update strategy the same way the Deployment and Rollout controllers do
+func resolveRollingUpdate(w workload, replicas int64) (rollingUpdate, error) {
+	var fields []string
+	switch {
+	case w.resource.Kind == "Rollout":
+		if _, found, _ := unstructured.NestedMap(w.obj.Object, "spec", "strategy", "blueGreen"); found {
+			// The new pods are started next to the old ones
+			return rollingUpdate{strategy: "blue-green update"}, nil
+		}
+		fields = []string{"spec", "strategy", "canary"}
+	default:
+		if strategyType, _, _ := unstructured.NestedString(w.obj.Object, "spec", "strategy", "type"); strategyType == "Recreate" {
+			return rollingUpdate{strategy: "Recreate update", unavailable: replicas}, nil
+		}
+		fields = []string{"spec", "strategy", "rollingUpdate"}
+	}
+
+	// Deployments and canary Rollouts both default maxSurge and maxUnavailable to 25%
+	defaultValue := intstr.FromString("25%")
+	maxSurge, found, err := intOrString(w.obj, append(fields, "maxSurge")...)
+	if err != nil {
+		return rollingUpdate{}, err
+	}
+	if !found {
+		maxSurge = &defaultValue
+	}
+	maxUnavailable, found, err := intOrString(w.obj, append(fields, "maxUnavailable")...)
+	if err != nil {
+		return rollingUpdate{}, err
+	}
+	if !found {
+		maxUnavailable = &defaultValue
+	}
+
+	surge, err := intstr.GetValueFromIntOrPercent(maxSurge, int(replicas), true)
+	if err != nil {
+		return rollingUpdate{}, err
+	}
+	unavailable, err := intstr.GetValueFromIntOrPercent(maxUnavailable, int(replicas), false)
+	if err != nil {
+		return rollingUpdate{}, err
+	}
+	update := rollingUpdate{strategy: "rolling update", unavailable: int64(unavailable)}
+	// The API server rejects 0 for both, the controller takes one pod down if both round down to 0
+	if isZeroIntOrPercent(maxSurge) && isZeroIntOrPercent(maxUnavailable) {
+		update.stalls = true
+	} else if surge == 0 && unavailable == 0 {
+		update.unavailable = 1
+	}
+	return update, nil
+}
+
+// allowedDisruptions calculates how many pods could be evicted the same way the disruption controller does
+func allowedDisruptions(pdb *unstructured.Unstructured, replicas int64) (int64, error) {
+	if value, ok, err := intOrString(pdb, "spec", "maxUnavailable"); err != nil || ok {
+		if err != nil {
+			return 0, err
+		}
+		maxUnavailable, err := intstr.GetValueFromIntOrPercent(value, int(replicas), true)
+		return int64(maxUnavailable), err
+	}
+	if value, ok, err := intOrString(pdb, "spec", "minAvailable"); err != nil || ok {
+		if err != nil {
+			return 0, err
+		}
+		minAvailable, err := intstr.GetValueFromIntOrPercent(value, int(replicas), true)
+		return replicas - int64(minAvailable), err
+	}
+	// Neither is set, the API server defaults minAvailable to 1
+	return replicas - 1, nil
+}
+
+// isZeroIntOrPercent tells whether the value is 0 or "0%"
+func isZeroIntOrPercent(value *intstr.IntOrString) bool {
+	if value.Type == intstr.Int {
+		return value.IntValue() == 0
+	}
+	return strings.TrimSuffix(value.StrVal, "%") == "0"
+}
+
+// intOrString reads an int or percentage field of an unstructured object
+func intOrString(obj *unstructured.Unstructured, fields ...string) (*intstr.IntOrString, bool, error) {
+	value, found, err := unstructured.NestedFieldNoCopy(obj.Object, fields...)
+	if err != nil || !found || value == nil {
+		return nil, false, err
+	}
+	switch v := value.(type) {
+	case int64:
+		result := intstr.FromInt(int(v))
+		return &result, true, nil
+	case float64:
+		result := intstr.FromInt(int(v))
+		return &result, true, nil
+	case string:
+		result := intstr.FromString(v)
+		return &result, true, nil
+	}
+	return nil, false, fmt.Errorf("%s should be an integer or a percentage, got %v", strings.Join(fields, "."), value)
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+const pdbDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web-prd
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: web:latest
+`
+
+func manifestsToResourceDiffs(t *testing.T, manifests ...string) []*argoappv1.ResourceDiff {
+	objs, err := decodeManifests(strings.NewReader(strings.Join(manifests, "\n---\n")), "test")
+	assert.Nil(t, err)
+	diffs, err := manifestResourceDiffs(objs, nil)
+	assert.Nil(t, err)
+	return diffs
+}
+
+func pdbManifest(name string, app string, budget string) string {
+	return `
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: ` + name + `
+  namespace: web-prd
+spec:
+  ` + budget + `
+  selector:
+    matchLabels:
+      app: ` + app + `
+`
+}
+
+func findingRules(findings []Finding) []string {
+	rules := make([]string, 0)
+	for _, finding := range findings {
+		rules = append(rules, finding.Rule)
+	}
+	return rules
+}
+
+func TestPdbMissingInProduction(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, pdbDeployment)
+	assert.Equal(t, []string{"pdb-missing"}, findingRules(verifyPdb(diffs, true)))
+	assert.Empty(t, verifyPdb(diffs, false))
+}
+
+func TestPdbAllowsEviction(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, pdbDeployment, pdbManifest("web-pdb", "web", "minAvailable: 1"))
+	assert.Empty(t, verifyPdb(diffs, true))
+
+	diffs = manifestsToResourceDiffs(t, pdbDeployment, pdbManifest("web-pdb", "web", "maxUnavailable: 50%"))
+	assert.Empty(t, verifyPdb(diffs, true))
+}
+
+func TestPdbBlocksEviction(t *testing.T) {
+	for _, budget := range []string{"minAvailable: 2", "minAvailable: 100%", "maxUnavailable: 0"} {
+		diffs := manifestsToResourceDiffs(t, pdbDeployment, pdbManifest("web-pdb", "web", budget))
+		findings := verifyPdb(diffs, true)
+		assert.Equal(t, []string{"pdb-blocks-eviction"}, findingRules(findings), budget)
+		assert.EqualValues(t, "PodDisruptionBudget", findings[0].Kind)
+	}
+}
+
+func TestPdbBlocksEvictionWithHpaMinReplicas(t *testing.T) {
+	hpa := `
+apiVersion: autoscaling/v1
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+  namespace: web-prd
+spec:
+  minReplicas: 3
+  maxReplicas: 10
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web
+`
+	diffs := manifestsToResourceDiffs(t, pdbDeployment, hpa, pdbManifest("web-pdb", "web", "minAvailable: 3"))
+	findings := verifyPdb(diffs, true)
+	assert.Equal(t, []string{"pdb-blocks-eviction"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "HPA minReplicas")
+}
+
+func TestPdbBlocksEvictionOverHpaRange(t *testing.T) {
+	hpa := `
+apiVersion: autoscaling/v1
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+  namespace: web-prd
+spec:
+  minReplicas: 2
+  maxReplicas: 3
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web
+`
+	diffs := manifestsToResourceDiffs(t, pdbDeployment, hpa, pdbManifest("web-pdb", "web", "minAvailable: 3"))
+	findings := verifyPdb(diffs, true)
+	assert.Equal(t, []string{"pdb-blocks-eviction"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "not even with 3 replicas")
+
+	diffs = manifestsToResourceDiffs(t, pdbDeployment, hpa, pdbManifest("web-pdb", "web", "minAvailable: 2"))
+	findings = verifyPdb(diffs, true)
+	assert.Equal(t, []string{"pdb-blocks-eviction"}, findingRules(findings))
+	assert.NotContains(t, findings[0].Message, "not even")
+}
+
+func pdbDeploymentWithStrategy(replicas string, strategy string) string {
+	return strings.Replace(pdbDeployment, "  replicas: 2\n", "  replicas: "+replicas+"\n  strategy:\n"+strategy, 1)
+}
+
+func TestPdbRolloutStalls(t *testing.T) {
+	deployment := pdbDeploymentWithStrategy("2", `
+    type: RollingUpdate
+    rollingUpdate:
+      maxSurge: 0
+      maxUnavailable: 0%
+`)
+	findings := verifyPdb(manifestsToResourceDiffs(t, deployment), false)
+	assert.Equal(t, []string{"pdb-rollout-stalls"}, findingRules(findings))
+	assert.EqualValues(t, "Deployment", findings[0].Kind)
+
+	// Both round down to 0 with 2 replicas, the controller still takes one pod down
+	deployment = pdbDeploymentWithStrategy("2", `
+    rollingUpdate:
+      maxSurge: 0
+      maxUnavailable: 25%
+`)
+	assert.Empty(t, verifyPdb(manifestsToResourceDiffs(t, deployment, pdbManifest("web-pdb", "web", "minAvailable: 1")), true))
+}
+
+func TestPdbRolloutDisrupts(t *testing.T) {
+	deployment := pdbDeploymentWithStrategy("4", `
+    rollingUpdate:
+      maxSurge: 0
+      maxUnavailable: 2
+`)
+	findings := verifyPdb(manifestsToResourceDiffs(t, deployment, pdbManifest("web-pdb", "web", "maxUnavailable: 1")), true)
+	assert.Equal(t, []string{"pdb-rollout-disrupts"}, findingRules(findings))
+	assert.Equal(t, SeverityWarning, findings[0].Severity)
+	assert.Contains(t, findings[0].Message, "takes down up to 2 of 4 pods")
+
+	deployment = pdbDeploymentWithStrategy("2", `
+    type: Recreate
+`)
+	findings = verifyPdb(manifestsToResourceDiffs(t, deployment, pdbManifest("web-pdb", "web", "minAvailable: 1")), true)
+	assert.Equal(t, []string{"pdb-rollout-disrupts"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "Recreate update")
+
+	rollout := strings.Replace(strings.Replace(pdbDeployment, "apps/v1", "argoproj.io/v1alpha1", 1), "kind: Deployment", "kind: Rollout", 1)
+	rollout = strings.Replace(rollout, "  replicas: 2\n", "  replicas: 2\n  strategy:\n    canary:\n      maxSurge: 1\n", 1)
+	assert.Empty(t, verifyPdb(manifestsToResourceDiffs(t, rollout, pdbManifest("web-pdb", "web", "minAvailable: 1")), true))
+
+	// The canary maxUnavailable defaults to 25% like the one of a Deployment
+	rollout = strings.Replace(rollout, "  replicas: 2\n", "  replicas: 8\n", 1)
+	findings = verifyPdb(manifestsToResourceDiffs(t, rollout, pdbManifest("web-pdb", "web", "maxUnavailable: 1")), true)
+	assert.Equal(t, []string{"pdb-rollout-disrupts"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "takes down up to 2 of 8 pods")
+}
+
+func TestPdbSelectsNothing(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, pdbDeployment, pdbManifest("web-pdb", "web", "minAvailable: 1"), pdbManifest("other-pdb", "other", "minAvailable: 1"))
+	findings := verifyPdb(diffs, true)
+	assert.Equal(t, []string{"pdb-selects-nothing"}, findingRules(findings))
+	assert.EqualValues(t, "other-pdb", findings[0].Name)
+}
+
+func TestIsProductionApp(t *testing.T) {
+	for _, name := range []string{"web-prd", "web-prod", "web-production"} {
+		assert.True(t, isProductionApp(name), name)
+	}
+	for _, name := range []string{"web-qal", "web-prd-canary", "webprd"} {
+		assert.False(t, isProductionApp(name), name)
+	}
+}
+
+func pdbEmptySelector(apiVersion string) string {
+	return `
+apiVersion: ` + apiVersion + `
+kind: PodDisruptionBudget
+metadata:
+  name: all-pdb
+  namespace: web-prd
+spec:
+  minAvailable: 1
+  selector: {}
+`
+}
+
+func TestPdbEmptySelector(t *testing.T) {
+	// policy/v1 selects every pod of the namespace
+	assert.Empty(t, verifyPdb(manifestsToResourceDiffs(t, pdbDeployment, pdbEmptySelector("policy/v1")), true))
+
+	// policy/v1beta1 selects none
+	findings := verifyPdb(manifestsToResourceDiffs(t, pdbDeployment, pdbEmptySelector("policy/v1beta1")), true)
+	assert.Equal(t, []string{"pdb-missing", "pdb-selects-nothing"}, findingRules(findings))
+}
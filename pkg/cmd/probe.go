@@ -0,0 +1,112 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register(&probeGuard{})
+}
+
+// probeGuard makes sure the probes of the containers point to ports the containers expose
+type probeGuard struct{}
+
+func (g *probeGuard) Name() string {
+	return "probe"
+}
+
+func (g *probeGuard) Description() string {
+	return "Check readiness, liveness and startup probes of Deployment and Rollout"
+}
+
+func (g *probeGuard) Evaluate(app *GuardContext) ([]Finding, error) {
+	return verifyProbes(app.Resources, app.Production), nil
+}
+
+// probeKinds are the probes of a container
+var probeKinds = []string{"readinessProbe", "livenessProbe", "startupProbe"}
+
+func verifyProbes(resourceDiffs []*argoappv1.ResourceDiff, production bool) []Finding {
+	findings := make([]Finding, 0)
+	for _, w := range workloads(resourceDiffs) {
+		template, err := podTemplate(w.obj)
+		if err != nil {
+			findings = append(findings, newResourceFinding("probe-workload-invalid", SeverityWarning, w.resource,
+				fmt.Sprintf("The pod template has error %v", err), ""))
+			continue
+		}
+		//The probes are read from the unstructured template, grpc probes are not known by the API types
+		containers, _, _ := unstructured.NestedSlice(w.obj.Object, "spec", "template", "spec", "containers")
+		for i, containerObj := range containers {
+			container := toMap(containerObj)
+			if i >= len(template.Spec.Containers) || container == nil {
+				continue
+			}
+			containerName := template.Spec.Containers[i].Name
+			for _, probeKind := range probeKinds {
+				probe := toMap(container[probeKind])
+				if probe == nil {
+					continue
+				}
+				for _, handler := range []string{"httpGet", "tcpSocket", "grpc"} {
+					action := toMap(probe[handler])
+					if action == nil {
+						continue
+					}
+					if port := portString(action["port"]); port != "" && !declaresPort(template.Spec.Containers[i:i+1], port) {
+						if _, err := strconv.Atoi(port); err != nil {
+							findings = append(findings, newResourceFinding("probe-port-missing", SeverityError, w.resource,
+								fmt.Sprintf("The %s of container %s in %s:%s refers to the port name '%s' which the container doesn't have", probeKind, containerName, w.resource.Kind, w.resource.Name, port),
+								"Name a port of the container after the probe port or use the port number"))
+						} else {
+							findings = append(findings, newResourceFinding("probe-port-missing", SeverityWarning, w.resource,
+								fmt.Sprintf("The %s of container %s in %s:%s probes the port %s which the container doesn't declare", probeKind, containerName, w.resource.Kind, w.resource.Name, port),
+								"Make sure the container listens on the probe port and declare it in 'ports' of the container"))
+						}
+					}
+					if path, _ := action["path"].(string); handler == "httpGet" && path != "" && !strings.HasPrefix(path, "/") {
+						findings = append(findings, newResourceFinding("probe-path-invalid", SeverityWarning, w.resource,
+							fmt.Sprintf("The %s of container %s in %s:%s has the path '%s' which doesn't start with '/'", probeKind, containerName, w.resource.Kind, w.resource.Name, path),
+							"Start the path of the probe with '/'"))
+					}
+				}
+			}
+
+			readiness, liveness := container["readinessProbe"], container["livenessProbe"]
+			if production && readiness != nil && reflect.DeepEqual(readiness, liveness) {
+				findings = append(findings, newResourceFinding("probe-liveness-same-as-readiness", SeverityWarning, w.resource,
+					fmt.Sprintf("The livenessProbe of container %s in %s:%s is the same as the readinessProbe, a slow dependency restarts the pods instead of taking them out of service", containerName, w.resource.Kind, w.resource.Name),
+					"Let the livenessProbe only check the process itself, or give it a higher 'failureThreshold' than the readinessProbe"))
+			}
+		}
+	}
+
+	if len(findings) == 0 {
+		log.Infof("Probes are good to pass through")
+	}
+	return findings
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func probeDeployment(probes string) string {
+	return `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: web:latest
+        ports:
+        - name: http
+          containerPort: 8080
+` + probes
+}
+
+func TestProbePorts(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, probeDeployment(`
+        readinessProbe:
+          httpGet:
+            path: /ready
+            port: http
+        livenessProbe:
+          tcpSocket:
+            port: 8080
+        startupProbe:
+          grpc:
+            port: 8080
+`))
+	assert.Empty(t, verifyProbes(diffs, true))
+
+	diffs = manifestsToResourceDiffs(t, probeDeployment(`
+        readinessProbe:
+          httpGet:
+            path: ready
+            port: https
+        livenessProbe:
+          tcpSocket:
+            port: 9090
+`))
+	findings := verifyProbes(diffs, false)
+	assert.Equal(t, []string{"probe-port-missing", "probe-path-invalid", "probe-port-missing"}, findingRules(findings))
+	assert.Equal(t, SeverityError, findings[0].Severity)
+	assert.Contains(t, findings[0].Message, "readinessProbe of container app")
+	assert.Equal(t, SeverityWarning, findings[2].Severity)
+}
+
+func TestProbeLivenessSameAsReadiness(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, probeDeployment(`
+        readinessProbe:
+          httpGet:
+            path: /health
+            port: http
+        livenessProbe:
+          httpGet:
+            path: /health
+            port: http
+`))
+	assert.Equal(t, []string{"probe-liveness-same-as-readiness"}, findingRules(verifyProbes(diffs, true)))
+	assert.Empty(t, verifyProbes(diffs, false))
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/argoproj/argo-cd/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+// Guard is a single validation which runs against the resources of an application.
+// Every registered guard gets its own sub command and is executed by `all`.
+type Guard interface {
+	// Name is used as the sub command name, e.g. "hpa"
+	Name() string
+	// Description is the short help of the sub command
+	Description() string
+	// Evaluate verifies the resources of the application and returns every problem it found,
+	// an error is returned only if the guard is not able to evaluate the application
+	Evaluate(app *GuardContext) ([]Finding, error)
+}
+
+// GuardContext carries the application being guarded
+type GuardContext struct {
+	Ctx       context.Context
+	AppName   string
+	Resources []*argoappv1.ResourceDiff
+
+	// AppIf is the Argo CD application client, Application the refreshed application and Project its AppProject.
+	// They are nil unless the application comes from Argo CD, Project is nil too if it can't be read.
+	AppIf       application.ApplicationServiceClient
+	Application *argoappv1.Application
+	Project     *argoappv1.AppProject
+
+	// Patcher is used by guards which need to make a slight change on the live objects, it is nil in offline mode
+	Patcher Patcher
+	DryRun  bool
+
+	// Config tunes the guards, it is never nil
+	Config *GuardConfig
+
+	// Production is true if the application is deployed to production, some guards are stricter in production
+	Production bool
+}
+
+// Patcher applies a JSON merge patch on the live object of a resource
+type Patcher interface {
+	Patch(ctx context.Context, resource *argoappv1.ResourceDiff, namespace string, apiVersion string, patch []byte) error
+}
+
+var (
+	guards     = make([]Guard, 0)
+	guardNames = make(map[string]Guard)
+
+	// reservedNames are sub commands which are not guards
+	reservedNames = map[string]bool{"all": true, "help": true, "webhook": true, "sync": true, "undo": true}
+)
+
+// Register adds a guard to the registry, guards are executed by `all` in the order they are registered
+func Register(guard Guard) {
+	name := guard.Name()
+	if reservedNames[name] {
+		panic(fmt.Sprintf("guard name '%s' is reserved", name))
+	}
+	if _, ok := guardNames[name]; ok {
+		panic(fmt.Sprintf("guard '%s' is already registered", name))
+	}
+	guardNames[name] = guard
+	guards = append(guards, guard)
+}
+
+// Guards returns all registered guards
+func Guards() []Guard {
+	return append([]Guard(nil), guards...)
+}
+
+// LookupGuard returns the guard registered with the given name, or nil
+func LookupGuard(name string) Guard {
+	return guardNames[name]
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisteredGuards(t *testing.T) {
+	names := make([]string, 0)
+	for _, guard := range Guards() {
+		names = append(names, guard.Name())
+	}
+	assert.Equal(t, []string{"hpa", "immutable", "ingress", "namespace", "pdb", "probe", "rollout", "service"}, names)
+	assert.NotNil(t, LookupGuard("hpa"))
+	assert.Nil(t, LookupGuard("all"))
+}
+
+func TestRegisterDuplicateGuard(t *testing.T) {
+	assert.Panics(t, func() {
+		Register(&hpaGuard{})
+	})
+}
+
+func TestGuardCommands(t *testing.T) {
+	command := NewCmdGuard(nil)
+	for _, name := range []string{"hpa", "ingress", "pdb", "probe", "rollout", "service", "immutable", "namespace", "all", "webhook", "sync", "undo"} {
+		c, _, err := command.Find([]string{name})
+		assert.Nil(t, err)
+		assert.Equal(t, name, c.Name())
+	}
+}
+
+func TestAppNameFromArgs(t *testing.T) {
+	assert.Equal(t, "my-app", appNameFromArgs([]string{"my-app"}))
+	assert.Equal(t, "my-app", appNameFromArgs([]string{"--unknown", "my-app"}))
+	assert.Equal(t, "", appNameFromArgs([]string{"--unknown"}))
+	assert.Equal(t, "", appNameFromArgs(nil))
+}
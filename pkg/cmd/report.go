@@ -0,0 +1,454 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Supported report formats of --output
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputJUnit = "junit"
+	outputSARIF = "sarif"
+)
+
+// Report is the result of running guards against an application, or against many applications when Apps is set
+type Report struct {
+	App      string        `json:"app,omitempty"`
+	Apps     []AppResult   `json:"apps,omitempty"`
+	Guards   []GuardResult `json:"guards"`
+	Findings []Finding     `json:"findings"`
+	// Suppressed are the findings bypassed by the skip annotations, they don't fail the guards
+	Suppressed []Finding `json:"suppressed,omitempty"`
+	// Fixed are the findings of the live state which this change fixes
+	Fixed []Finding `json:"fixed,omitempty"`
+
+	// failOn decides which findings fail the guards, see --fail-on
+	failOn string
+}
+
+// GuardResult tells whether a guard was able to evaluate the application
+type GuardResult struct {
+	App   string `json:"app,omitempty"`
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// Status of an application in a combined report
+const (
+	appStatusPassed = "passed"
+	appStatusFailed = "failed"
+	appStatusError  = "error"
+)
+
+// AppResult is the status of a single application of a combined report
+type AppResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Errors   int    `json:"errors"`
+	Warnings int    `json:"warnings"`
+	Error    string `json:"error,omitempty"`
+}
+
+// newCombinedReport creates an empty report for many applications
+func newCombinedReport() *Report {
+	return &Report{
+		Apps:     make([]AppResult, 0),
+		Guards:   make([]GuardResult, 0),
+		Findings: make([]Finding, 0),
+	}
+}
+
+// addApp adds the report of an application to the combined report
+func (r *Report) addApp(app *Report) {
+	result := AppResult{Name: app.App, Status: appStatusPassed}
+	app.failOn = r.failOn
+	for _, guard := range app.Guards {
+		guard.App = app.App
+		r.Guards = append(r.Guards, guard)
+	}
+	for _, finding := range app.Suppressed {
+		finding.App = app.App
+		r.Suppressed = append(r.Suppressed, finding)
+	}
+	for _, finding := range app.Fixed {
+		finding.App = app.App
+		r.Fixed = append(r.Fixed, finding)
+	}
+	for _, finding := range app.Findings {
+		finding.App = app.App
+		r.Findings = append(r.Findings, finding)
+		switch finding.Severity {
+		case SeverityError:
+			result.Errors++
+		case SeverityWarning:
+			result.Warnings++
+		}
+	}
+	switch app.exitCode() {
+	case exitCodeViolations:
+		result.Status = appStatusFailed
+	case exitCodeGuardError:
+		result.Status = appStatusError
+	}
+	r.Apps = append(r.Apps, result)
+}
+
+// addAppError adds an application which could not be guarded to the combined report
+func (r *Report) addAppError(appName string, err error) {
+	r.Apps = append(r.Apps, AppResult{Name: appName, Status: appStatusError, Error: err.Error()})
+}
+
+// guardErrors counts the guards which were not able to evaluate the application, and the applications which could not be guarded
+func (r *Report) guardErrors() int {
+	count := 0
+	for _, guard := range r.Guards {
+		if guard.Error != "" {
+			count++
+		}
+	}
+	for _, app := range r.Apps {
+		if app.Error != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// logApps writes the status of every application of a combined report to the log
+func logApps(apps []AppResult) {
+	for _, app := range apps {
+		switch app.Status {
+		case appStatusPassed:
+			log.Infof("Application %s %s (%d warnings)", app.Name, app.Status, app.Warnings)
+		case appStatusFailed:
+			log.Errorf("Application %s %s (%d errors, %d warnings)", app.Name, app.Status, app.Errors, app.Warnings)
+		default:
+			log.Errorf("Application %s %s %s", app.Name, app.Status, app.Error)
+		}
+	}
+}
+
+// exitCode decides the exit status of the command for the report
+func (r *Report) exitCode() int {
+	return exitCode(failingFindings(r.Findings, r.failOn), r.guardErrors())
+}
+
+// validateOutput checks the --output flag before any guard runs
+func validateOutput(output string) error {
+	switch output {
+	case "", outputText, outputJSON, outputJUnit, outputSARIF:
+		return nil
+	}
+	return fmt.Errorf("unknown output format '%s', should be one of: %s|%s|%s|%s", output, outputText, outputJSON, outputJUnit, outputSARIF)
+}
+
+// publishReport writes the findings to the log, and the machine readable report to the report file or stdout
+func publishReport(report *Report, output string, reportFile string) error {
+	logFindings(report.Findings)
+	logSuppressed(report.Suppressed)
+	logFixed(report.Fixed)
+	logApps(report.Apps)
+	if output == "" || output == outputText {
+		return nil
+	}
+
+	w := io.Writer(os.Stdout)
+	if reportFile != "" {
+		f, err := os.Create(reportFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return writeReport(w, output, report)
+}
+
+func writeReport(w io.Writer, output string, report *Report) error {
+	switch output {
+	case outputJSON:
+		return writeJSON(w, report)
+	case outputJUnit:
+		return writeJUnit(w, report)
+	case outputSARIF:
+		return writeSARIF(w, report)
+	}
+	return validateOutput(output)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr,omitempty"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr,omitempty"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes one test suite per guard and one test case per finding, error findings are failures.
+// A guard without finding is a single passed test case.
+func writeJUnit(w io.Writer, report *Report) error {
+	suites := junitTestSuites{Name: report.App}
+	for _, guard := range report.Guards {
+		suiteName := guard.Name
+		if guard.App != "" {
+			suiteName = guard.App + "/" + guard.Name
+		}
+		suite := junitTestSuite{Name: suiteName}
+		if guard.Error != "" {
+			suite.TestCases = append(suite.TestCases, junitTestCase{
+				Name:      guard.Name,
+				ClassName: suiteName,
+				Error:     &junitMessage{Message: guard.Error},
+			})
+			suite.Errors++
+		}
+		for _, finding := range report.Findings {
+			if finding.Guard != guard.Name || finding.App != guard.App {
+				continue
+			}
+			testCase := junitTestCase{
+				Name:      fmt.Sprintf("%s %s", finding.Rule, finding.Resource()),
+				ClassName: suiteName,
+			}
+			if finding.Severity == SeverityError && failing(finding, report.failOn) {
+				testCase.Failure = &junitMessage{Message: finding.Message, Type: finding.Rule, Text: finding.String()}
+				suite.Failures++
+			} else {
+				testCase.SystemOut = finding.String()
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		for _, finding := range report.Suppressed {
+			if finding.Guard != guard.Name || finding.App != guard.App {
+				continue
+			}
+			suite.TestCases = append(suite.TestCases, junitTestCase{
+				Name:      fmt.Sprintf("%s %s", finding.Rule, finding.Resource()),
+				ClassName: suiteName,
+				Skipped:   &junitMessage{Message: finding.Suppression.Reason, Text: finding.String()},
+			})
+			suite.Skipped++
+		}
+		if len(suite.TestCases) == 0 {
+			suite.TestCases = append(suite.TestCases, junitTestCase{Name: guard.Name, ClassName: suiteName})
+		}
+		suite.Tests = len(suite.TestCases)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Suites = append(suites.Suites, suite)
+	}
+	for _, app := range report.Apps {
+		if app.Error == "" {
+			continue
+		}
+		suites.Suites = append(suites.Suites, junitTestSuite{
+			Name:      app.Name,
+			Tests:     1,
+			Errors:    1,
+			TestCases: []junitTestCase{{Name: app.Name, ClassName: app.Name, Error: &junitMessage{Message: app.Error}}},
+		})
+		suites.Tests++
+		suites.Errors++
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+	// BaselineState is "new", "unchanged" or "absent" compared with the live state
+	BaselineState string `json:"baselineState,omitempty"`
+	// Suppressions marks the findings bypassed by the skip annotations
+	Suppressions []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Justification string `json:"justification"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// writeSARIF writes the findings as a SARIF 2.1.0 log, resources are logical locations
+func writeSARIF(w io.Writer, report *Report) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "cd-guard",
+			InformationURI: "https://github.com/keikoproj/cd-guard",
+			Rules:          make([]sarifRule, 0),
+		}},
+		Results: make([]sarifResult, 0),
+	}
+
+	rules := make(map[string]bool)
+	findings := append(append(append([]Finding(nil), report.Findings...), report.Suppressed...), report.Fixed...)
+	for _, finding := range findings {
+		if !rules[finding.Rule] {
+			rules[finding.Rule] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:               finding.Rule,
+				ShortDescription: sarifMessage{Text: fmt.Sprintf("%s guard rule %s", finding.Guard, finding.Rule)},
+			})
+		}
+
+		result := sarifResult{
+			RuleID:     finding.Rule,
+			Level:      sarifLevel(finding, report.failOn),
+			Message:    sarifMessage{Text: finding.Message},
+			Properties: map[string]string{"guard": finding.Guard},
+		}
+		if finding.Fix != "" {
+			result.Properties["fix"] = finding.Fix
+		}
+		if finding.App != "" {
+			result.Properties["app"] = finding.App
+		}
+		switch finding.Change {
+		case changeNew:
+			result.BaselineState = "new"
+		case changeExisting:
+			result.BaselineState = "unchanged"
+		case changeFixed:
+			result.BaselineState = "absent"
+		}
+		if finding.Suppression != nil {
+			result.Suppressions = []sarifSuppression{{Kind: "inSource", Justification: finding.Suppression.Reason}}
+		}
+		if resource := finding.Resource(); resource != "" {
+			result.Locations = []sarifLocation{{LogicalLocations: []sarifLogicalLocation{{
+				Name:               finding.Name,
+				FullyQualifiedName: resource,
+				Kind:               "resource",
+			}}}}
+		}
+		run.Results = append(run.Results, result)
+	}
+	sort.Slice(run.Tool.Driver.Rules, func(i, j int) bool {
+		return run.Tool.Driver.Rules[i].ID < run.Tool.Driver.Rules[j].ID
+	})
+
+	return writeJSON(w, sarifLog{Schema: sarifSchema, Version: "2.1.0", Runs: []sarifRun{run}})
+}
+
+// sarifLevel returns the level of the finding, an error which doesn't fail the guards with --fail-on is a warning
+func sarifLevel(finding Finding, failOn string) string {
+	switch finding.Severity {
+	case SeverityError:
+		if !failing(finding, failOn) {
+			return "warning"
+		}
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "note"
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testReport() *Report {
+	return &Report{
+		App:    "web-prd",
+		Guards: []GuardResult{{Name: "hpa"}, {Name: "ingress"}, {Name: "pdb", Error: "broken"}},
+		Findings: []Finding{
+			{Guard: "ingress", Rule: "ingress-readiness-gate-missing", Severity: SeverityError, Group: "extensions", Kind: "Ingress", Namespace: "web", Name: "web", Message: "no pod enables PodReadinessGate", Fix: "read the doc"},
+			{Guard: "ingress", Rule: "ingress-workload-invalid", Severity: SeverityWarning, Group: "apps", Kind: "Deployment", Namespace: "web", Name: "web", Message: "bad target"},
+		},
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+	for _, output := range []string{"", "text", "json", "junit", "sarif"} {
+		assert.Nil(t, validateOutput(output))
+	}
+	assert.NotNil(t, validateOutput("yaml"))
+}
+
+func TestWriteJSONReport(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, writeReport(&b, outputJSON, testReport()))
+
+	report := &Report{}
+	assert.Nil(t, json.Unmarshal(b.Bytes(), report))
+	assert.Equal(t, testReport(), report)
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, writeReport(&b, outputJUnit, testReport()))
+
+	suites := junitTestSuites{}
+	assert.Nil(t, xml.Unmarshal(b.Bytes(), &suites))
+	assert.EqualValues(t, 4, suites.Tests)
+	assert.EqualValues(t, 1, suites.Failures)
+	assert.EqualValues(t, 1, suites.Errors)
+	assert.EqualValues(t, 3, len(suites.Suites))
+
+	assert.EqualValues(t, "hpa", suites.Suites[0].Name)
+	assert.Nil(t, suites.Suites[0].TestCases[0].Failure)
+
+	ingress := suites.Suites[1]
+	assert.EqualValues(t, 2, ingress.Tests)
+	assert.EqualValues(t, "ingress-readiness-gate-missing Ingress.extensions:web/web", ingress.TestCases[0].Name)
+	assert.NotNil(t, ingress.TestCases[0].Failure)
+	assert.Nil(t, ingress.TestCases[1].Failure)
+
+	assert.NotNil(t, suites.Suites[2].TestCases[0].Error)
+}
+
+func TestWriteSARIFReport(t *testing.T) {
+	var b bytes.Buffer
+	assert.Nil(t, writeReport(&b, outputSARIF, testReport()))
+
+	log := sarifLog{}
+	assert.Nil(t, json.Unmarshal(b.Bytes(), &log))
+	assert.EqualValues(t, "2.1.0", log.Version)
+	run := log.Runs[0]
+	assert.EqualValues(t, 2, len(run.Tool.Driver.Rules))
+	assert.EqualValues(t, 2, len(run.Results))
+	assert.EqualValues(t, "error", run.Results[0].Level)
+	assert.EqualValues(t, "warning", run.Results[1].Level)
+	assert.EqualValues(t, "read the doc", run.Results[0].Properties["fix"])
+	assert.EqualValues(t, "Ingress.extensions:web/web", run.Results[0].Locations[0].LogicalLocations[0].FullyQualifiedName)
+}
+
+func TestCombinedReport(t *testing.T) {
+	report := newCombinedReport()
+	report.addApp(testReport())
+	report.addApp(&Report{App: "api-prd", Guards: []GuardResult{{Name: "hpa"}}, Findings: []Finding{}})
+	report.addAppError("db-prd", fmt.Errorf("permission denied"))
+
+	assert.Equal(t, []AppResult{
+		{Name: "web-prd", Status: appStatusFailed, Errors: 1, Warnings: 1},
+		{Name: "api-prd", Status: appStatusPassed},
+		{Name: "db-prd", Status: appStatusError, Error: "permission denied"},
+	}, report.Apps)
+	assert.Equal(t, "web-prd", report.Findings[0].App)
+	assert.Equal(t, 2, report.guardErrors())
+	assert.Equal(t, exitCodeViolations, report.exitCode())
+
+	var b bytes.Buffer
+	assert.Nil(t, writeReport(&b, outputJUnit, report))
+	suites := junitTestSuites{}
+	assert.Nil(t, xml.Unmarshal(b.Bytes(), &suites))
+	assert.EqualValues(t, 5, len(suites.Suites))
+	assert.EqualValues(t, "web-prd/ingress", suites.Suites[1].Name)
+	assert.EqualValues(t, 2, suites.Suites[1].Tests)
+	assert.EqualValues(t, "db-prd", suites.Suites[4].Name)
+	assert.EqualValues(t, 2, suites.Errors)
+}
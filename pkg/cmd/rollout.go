@@ -0,0 +1,226 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register(&rolloutGuard{})
+}
+
+// rolloutGuard makes sure the objects referenced by the canary and blue-green strategies of Argo Rollouts are part of the application
+type rolloutGuard struct{}
+
+func (g *rolloutGuard) Name() string {
+	return "rollout"
+}
+
+func (g *rolloutGuard) Description() string {
+	return "Check Services, AnalysisTemplates and traffic routing referenced by Rollout"
+}
+
+func (g *rolloutGuard) Evaluate(app *GuardContext) ([]Finding, error) {
+	return verifyRollouts(app.Resources), nil
+}
+
+// rolloutRef is an object referenced by name from a field of the Rollout strategy
+type rolloutRef struct {
+	field string
+	name  string
+	// clusterScope refers to a ClusterAnalysisTemplate instead of an AnalysisTemplate
+	clusterScope bool
+}
+
+func verifyRollouts(resourceDiffs []*argoappv1.ResourceDiff) []Finding {
+	findings := make([]Finding, 0)
+	services := servicesByName(resourceDiffs)
+	analysisTemplates := targetObjectsByName(resourceDiffs, "AnalysisTemplate", "argoproj.io")
+	clusterAnalysisTemplates := targetObjectsByName(resourceDiffs, "ClusterAnalysisTemplate", "argoproj.io")
+	ingresses := targetObjectsByName(resourceDiffs, "Ingress", "extensions", "networking.k8s.io")
+	virtualServices := targetObjectsByName(resourceDiffs, "VirtualService", "networking.istio.io")
+	destinationRules := targetObjectsByName(resourceDiffs, "DestinationRule", "networking.istio.io")
+
+	rollouts := 0
+	for _, w := range workloads(resourceDiffs) {
+		if w.resource.Kind != "Rollout" {
+			continue
+		}
+		rollouts++
+		template, err := podTemplate(w.obj)
+		if err != nil {
+			findings = append(findings, newResourceFinding("rollout-invalid", SeverityWarning, w.resource,
+				fmt.Sprintf("The pod template has error %v", err), ""))
+			continue
+		}
+
+		for _, ref := range rolloutServices(w.obj) {
+			service := services[ref.name]
+			if service == nil {
+				findings = append(findings, newResourceFinding("rollout-service-missing", SeverityError, w.resource,
+					fmt.Sprintf("'%s' of Rollout:%s refers to the Service %s which is not part of the application", ref.field, w.resource.Name, ref.name),
+					"Add the Service to the application or fix the name"))
+				continue
+			}
+			selector, _, _ := unstructured.NestedStringMap(service.Object, "spec", "selector")
+			if len(selector) == 0 || !labels.SelectorFromSet(selector).Matches(labels.Set(template.Labels)) {
+				findings = append(findings, newResourceFinding("rollout-service-selector", SeverityError, w.resource,
+					fmt.Sprintf("The selector %v of the Service %s ('%s') doesn't match the pod template labels of Rollout:%s", selector, ref.name, ref.field, w.resource.Name),
+					"Make 'spec.selector' of the Service match the pod template labels of the Rollout"))
+			}
+		}
+
+		for _, ref := range rolloutAnalysisTemplates(w.obj) {
+			if ref.clusterScope {
+				if clusterAnalysisTemplates[ref.name] == nil {
+					findings = append(findings, newResourceFinding("rollout-analysis-missing", SeverityWarning, w.resource,
+						fmt.Sprintf("'%s' of Rollout:%s refers to the ClusterAnalysisTemplate %s which is not part of the application", ref.field, w.resource.Name, ref.name),
+						"Make sure the ClusterAnalysisTemplate is installed in the cluster"))
+				}
+			} else if analysisTemplates[ref.name] == nil {
+				findings = append(findings, newResourceFinding("rollout-analysis-missing", SeverityError, w.resource,
+					fmt.Sprintf("'%s' of Rollout:%s refers to the AnalysisTemplate %s which is not part of the application", ref.field, w.resource.Name, ref.name),
+					"Add the AnalysisTemplate to the application, or set 'clusterScope: true' for a ClusterAnalysisTemplate"))
+			}
+		}
+
+		for _, ref := range rolloutTrafficRouting(w.obj, "alb", "ingress") {
+			if ingresses[ref.name] == nil {
+				findings = append(findings, newResourceFinding("rollout-ingress-missing", SeverityError, w.resource,
+					fmt.Sprintf("'%s' of Rollout:%s refers to the Ingress %s which is not part of the application", ref.field, w.resource.Name, ref.name),
+					"Add the Ingress to the application or fix the name"))
+			}
+		}
+		for _, ref := range rolloutTrafficRouting(w.obj, "istio", "virtualService") {
+			if virtualServices[ref.name] == nil {
+				findings = append(findings, newResourceFinding("rollout-virtualservice-missing", SeverityError, w.resource,
+					fmt.Sprintf("'%s' of Rollout:%s refers to the VirtualService %s which is not part of the application", ref.field, w.resource.Name, ref.name),
+					"Add the VirtualService to the application or fix the name"))
+			}
+		}
+		for _, ref := range rolloutTrafficRouting(w.obj, "istio", "destinationRule") {
+			if destinationRules[ref.name] == nil {
+				findings = append(findings, newResourceFinding("rollout-destinationrule-missing", SeverityError, w.resource,
+					fmt.Sprintf("'%s' of Rollout:%s refers to the DestinationRule %s which is not part of the application", ref.field, w.resource.Name, ref.name),
+					"Add the DestinationRule to the application or fix the name"))
+			}
+		}
+	}
+
+	if rollouts == 0 {
+		log.Infof("No Rollout found, good to pass through")
+	} else if len(findings) == 0 {
+		log.Infof("Rollouts are good to pass through")
+	}
+	return findings
+}
+
+// rolloutServices returns the Services of the canary and blue-green strategies
+func rolloutServices(rollout *unstructured.Unstructured) []rolloutRef {
+	refs := make([]rolloutRef, 0)
+	for _, field := range [][]string{
+		{"spec", "strategy", "canary", "canaryService"},
+		{"spec", "strategy", "canary", "stableService"},
+		{"spec", "strategy", "blueGreen", "activeService"},
+		{"spec", "strategy", "blueGreen", "previewService"},
+	} {
+		if name, _, _ := unstructured.NestedString(rollout.Object, field...); name != "" {
+			refs = append(refs, rolloutRef{field: strings.Join(field, "."), name: name})
+		}
+	}
+	return refs
+}
+
+// rolloutAnalysisTemplates returns the templates of the background, step, pre-promotion and post-promotion analysis
+func rolloutAnalysisTemplates(rollout *unstructured.Unstructured) []rolloutRef {
+	refs := make([]rolloutRef, 0)
+	for _, field := range [][]string{
+		{"spec", "strategy", "canary", "analysis"},
+		{"spec", "strategy", "blueGreen", "prePromotionAnalysis"},
+		{"spec", "strategy", "blueGreen", "postPromotionAnalysis"},
+	} {
+		if analysis, found, _ := unstructured.NestedMap(rollout.Object, field...); found {
+			refs = append(refs, analysisRefs(strings.Join(field, "."), analysis)...)
+		}
+	}
+	steps, _, _ := unstructured.NestedSlice(rollout.Object, "spec", "strategy", "canary", "steps")
+	for i, step := range steps {
+		if analysis := toMap(toMap(step)["analysis"]); analysis != nil {
+			refs = append(refs, analysisRefs(fmt.Sprintf("spec.strategy.canary.steps[%d].analysis", i), analysis)...)
+		}
+	}
+	return refs
+}
+
+// analysisRefs returns the templates of an analysis, 'templateName' is the single template of older Rollouts
+func analysisRefs(field string, analysis map[string]interface{}) []rolloutRef {
+	refs := make([]rolloutRef, 0)
+	if name, _ := analysis["templateName"].(string); name != "" {
+		refs = append(refs, rolloutRef{field: field + ".templateName", name: name})
+	}
+	templates, _ := analysis["templates"].([]interface{})
+	for i, template := range templates {
+		templateObj := toMap(template)
+		name, _ := templateObj["templateName"].(string)
+		clusterScope, _ := templateObj["clusterScope"].(bool)
+		if name != "" {
+			refs = append(refs, rolloutRef{field: fmt.Sprintf("%s.templates[%d].templateName", field, i), name: name, clusterScope: clusterScope})
+		}
+	}
+	return refs
+}
+
+// rolloutTrafficRouting returns the objects of the traffic router referenced by the given field,
+// both the single object, e.g. 'alb.ingress', and the list of newer Rollouts, e.g. 'alb.ingresses', are supported
+func rolloutTrafficRouting(rollout *unstructured.Unstructured, router string, field string) []rolloutRef {
+	refs := make([]rolloutRef, 0)
+	routing, found, _ := unstructured.NestedMap(rollout.Object, "spec", "strategy", "canary", "trafficRouting", router)
+	if !found {
+		return refs
+	}
+	prefix := "spec.strategy.canary.trafficRouting." + router + "."
+	switch v := routing[field].(type) {
+	case string: //alb.ingress
+		if v != "" {
+			refs = append(refs, rolloutRef{field: prefix + field, name: v})
+		}
+	case map[string]interface{}: //istio.virtualService.name
+		if name, _ := v["name"].(string); name != "" {
+			refs = append(refs, rolloutRef{field: prefix + field + ".name", name: name})
+		}
+	}
+	items, _ := routing[field+"s"].([]interface{})
+	for i, item := range items {
+		switch v := item.(type) {
+		case string: //alb.ingresses
+			refs = append(refs, rolloutRef{field: fmt.Sprintf("%s%ss[%d]", prefix, field, i), name: v})
+		case map[string]interface{}: //istio.virtualServices[].name
+			if name, _ := v["name"].(string); name != "" {
+				refs = append(refs, rolloutRef{field: fmt.Sprintf("%s%ss[%d].name", prefix, field, i), name: name})
+			}
+		}
+	}
+	return refs
+}
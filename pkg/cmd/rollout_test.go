@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rolloutCanary = `
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: web:latest
+  strategy:
+    canary:
+      canaryService: web-canary
+      stableService: web-stable
+      analysis:
+        templates:
+        - templateName: success-rate
+        - templateName: error-rate
+          clusterScope: true
+      steps:
+      - setWeight: 20
+      - analysis:
+          templates:
+          - templateName: smoke-test
+      trafficRouting:
+        alb:
+          ingress: web
+          rootService: web-root
+        istio:
+          virtualService:
+            name: web
+          destinationRule:
+            name: web
+`
+
+func rolloutService(name string, app string) string {
+	return `
+apiVersion: v1
+kind: Service
+metadata:
+  name: ` + name + `
+  namespace: web-qal
+spec:
+  selector:
+    app: ` + app + `
+  ports:
+  - port: 80
+`
+}
+
+func rolloutObject(apiVersion string, kind string, name string) string {
+	return `
+apiVersion: ` + apiVersion + `
+kind: ` + kind + `
+metadata:
+  name: ` + name + `
+  namespace: web-qal
+`
+}
+
+func TestRolloutReferencesExist(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, rolloutCanary,
+		rolloutService("web-canary", "web"), rolloutService("web-stable", "web"),
+		rolloutObject("argoproj.io/v1alpha1", "AnalysisTemplate", "success-rate"),
+		rolloutObject("argoproj.io/v1alpha1", "AnalysisTemplate", "smoke-test"),
+		rolloutObject("argoproj.io/v1alpha1", "ClusterAnalysisTemplate", "error-rate"),
+		rolloutObject("networking.k8s.io/v1", "Ingress", "web"),
+		rolloutObject("networking.istio.io/v1beta1", "VirtualService", "web"),
+		rolloutObject("networking.istio.io/v1beta1", "DestinationRule", "web"))
+	assert.Empty(t, verifyRollouts(diffs))
+}
+
+func TestRolloutReferencesMissing(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, rolloutCanary, rolloutService("web-stable", "api"))
+	findings := verifyRollouts(diffs)
+	assert.Equal(t, []string{
+		"rollout-service-missing",
+		"rollout-service-selector",
+		"rollout-analysis-missing",
+		"rollout-analysis-missing",
+		"rollout-analysis-missing",
+		"rollout-ingress-missing",
+		"rollout-virtualservice-missing",
+		"rollout-destinationrule-missing",
+	}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "'spec.strategy.canary.canaryService'")
+	assert.Equal(t, SeverityWarning, findings[3].Severity)
+	assert.Contains(t, findings[4].Message, "'spec.strategy.canary.steps[1].analysis.templates[0].templateName'")
+}
+
+func TestRolloutBlueGreen(t *testing.T) {
+	rollout := `
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+  strategy:
+    blueGreen:
+      activeService: web-active
+      previewService: web-preview
+      prePromotionAnalysis:
+        templateName: smoke-test
+`
+	diffs := manifestsToResourceDiffs(t, rollout, rolloutService("web-active", "web"))
+	findings := verifyRollouts(diffs)
+	assert.Equal(t, []string{"rollout-service-missing", "rollout-analysis-missing"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "web-preview")
+
+	assert.Empty(t, verifyRollouts(manifestsToResourceDiffs(t, pdbDeployment)))
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register(&serviceGuard{})
+}
+
+// serviceGuard makes sure every Service selects the pods of a workload of the application on ports the containers expose,
+// otherwise the endpoints and the target groups of the Ingress stay empty
+type serviceGuard struct{}
+
+func (g *serviceGuard) Name() string {
+	return "service"
+}
+
+func (g *serviceGuard) Description() string {
+	return "Check Service selectors and target ports against the workloads"
+}
+
+func (g *serviceGuard) Evaluate(app *GuardContext) ([]Finding, error) {
+	return verifyServices(app.Resources), nil
+}
+
+func verifyServices(resourceDiffs []*argoappv1.ResourceDiff) []Finding {
+	findings := make([]Finding, 0)
+	services := targetObjectsOfKind(resourceDiffs, "", "Service")
+	if len(services) == 0 {
+		log.Infof("No Service found, good to pass through")
+		return findings
+	}
+	workloads := podTemplateWorkloads(resourceDiffs)
+
+	for _, service := range services {
+		selector, _, _ := unstructured.NestedStringMap(service.Object, "spec", "selector")
+		serviceType, _, _ := unstructured.NestedString(service.Object, "spec", "type")
+		if len(selector) == 0 || serviceType == "ExternalName" { //Endpoints are managed by hand or there are none
+			continue
+		}
+
+		selected := make([]workload, 0)
+		for _, w := range workloads {
+			template, err := podTemplate(w.obj)
+			if err != nil {
+				continue
+			}
+			if sameNamespace(service, w.obj) && labels.SelectorFromSet(selector).Matches(labels.Set(template.Labels)) {
+				selected = append(selected, w)
+			}
+		}
+
+		if len(selected) == 0 {
+			findings = append(findings, newFinding("service-selects-nothing", SeverityError, service,
+				fmt.Sprintf("The selector %v of the Service %s matches the pod template labels of no workload in the application", selector, service.GetName()),
+				"Make 'spec.selector' match the pod template labels of a Deployment, Rollout, StatefulSet or DaemonSet"))
+			continue
+		}
+		if len(selected) > 1 {
+			names := make([]string, 0)
+			for _, w := range selected {
+				names = append(names, w.resource.Kind+":"+w.resource.Name)
+			}
+			findings = append(findings, newFinding("service-selects-multiple", SeverityWarning, service,
+				fmt.Sprintf("The selector %v of the Service %s matches the pods of several workloads (%s), the traffic is spread over all of them", selector, service.GetName(), strings.Join(names, ", ")),
+				"Add a label to 'spec.selector' which only the pods of one workload have"))
+		}
+
+		ports, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+		for _, portObj := range ports {
+			servicePort := toMap(portObj)
+			targetPort := portString(servicePort["targetPort"])
+			if targetPort == "" { //targetPort defaults to port
+				targetPort = portString(servicePort["port"])
+			}
+			_, err := strconv.Atoi(targetPort)
+			named := err != nil
+			for _, w := range selected {
+				template, _ := podTemplate(w.obj)
+				if declaresPort(template.Spec.Containers, targetPort) {
+					continue
+				}
+				if named {
+					findings = append(findings, newFinding("service-targetport-missing", SeverityError, service,
+						fmt.Sprintf("The targetPort '%s' of the Service %s is not a container port name of %s:%s", targetPort, service.GetName(), w.resource.Kind, w.resource.Name),
+						"Name a container port after the targetPort or use the port number"))
+				} else {
+					findings = append(findings, newFinding("service-targetport-missing", SeverityWarning, service,
+						fmt.Sprintf("The targetPort %s of the Service %s is not declared by the containers of %s:%s", targetPort, service.GetName(), w.resource.Kind, w.resource.Name),
+						"Make sure a container listens on the targetPort and declare it in 'ports' of the container"))
+				}
+			}
+		}
+	}
+
+	if len(findings) == 0 {
+		log.Infof("Services are good to pass through")
+	}
+	return findings
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serviceWorkload(kind string, name string, app string) string {
+	return `
+apiVersion: apps/v1
+kind: ` + kind + `
+metadata:
+  name: ` + name + `
+  namespace: web-qal
+spec:
+  selector:
+    matchLabels:
+      app: ` + app + `
+  template:
+    metadata:
+      labels:
+        app: ` + app + `
+        tier: frontend
+    spec:
+      containers:
+      - name: app
+        image: web:latest
+        ports:
+        - name: http
+          containerPort: 8080
+`
+}
+
+func serviceManifest(selector string, targetPort string) string {
+	return `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  selector:
+    ` + selector + `
+  ports:
+  - port: 80
+    targetPort: ` + targetPort + `
+`
+}
+
+func TestServiceSelectsWorkload(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, serviceWorkload("Deployment", "web", "web"), serviceManifest("app: web", "http"))
+	assert.Empty(t, verifyServices(diffs))
+
+	diffs = manifestsToResourceDiffs(t, serviceWorkload("StatefulSet", "web", "web"), serviceManifest("app: web", "8080"))
+	assert.Empty(t, verifyServices(diffs))
+}
+
+func TestServiceSelectsNothing(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, serviceWorkload("Deployment", "web", "web"), serviceManifest("app: api", "http"))
+	findings := verifyServices(diffs)
+	assert.Equal(t, []string{"service-selects-nothing"}, findingRules(findings))
+	assert.Equal(t, "Service", findings[0].Kind)
+}
+
+func TestServiceSelectsMultiple(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, serviceWorkload("Deployment", "web", "web"), serviceWorkload("Deployment", "api", "api"),
+		serviceManifest("tier: frontend", "http"))
+	findings := verifyServices(diffs)
+	assert.Equal(t, []string{"service-selects-multiple"}, findingRules(findings))
+	assert.Contains(t, findings[0].Message, "Deployment:web, Deployment:api")
+}
+
+func TestServiceTargetPortMissing(t *testing.T) {
+	diffs := manifestsToResourceDiffs(t, serviceWorkload("Deployment", "web", "web"), serviceManifest("app: web", "https"))
+	findings := verifyServices(diffs)
+	assert.Equal(t, []string{"service-targetport-missing"}, findingRules(findings))
+	assert.Equal(t, SeverityError, findings[0].Severity)
+
+	diffs = manifestsToResourceDiffs(t, serviceWorkload("Deployment", "web", "web"), serviceManifest("app: web", "9090"))
+	findings = verifyServices(diffs)
+	assert.Equal(t, []string{"service-targetport-missing"}, findingRules(findings))
+	assert.Equal(t, SeverityWarning, findings[0].Severity)
+}
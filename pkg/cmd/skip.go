@@ -0,0 +1,139 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"strings"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	log "github.com/sirupsen/logrus"
+)
+
+// Annotations of a resource or of the Argo CD Application opting out of guards, e.g. skip: "hpa,ingress".
+// A skip is only honoured together with its reason.
+const (
+	skipAnnotation       = "cd-guard.keikoproj.io/skip"
+	skipReasonAnnotation = "cd-guard.keikoproj.io/skip-reason"
+)
+
+// Suppression tells who bypassed a finding with the skip annotations and why
+type Suppression struct {
+	// Resource is the annotated resource, it is empty if the Argo CD Application is annotated
+	Resource string `json:"resource,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// skipReason returns the reason if the annotations skip the guard, "all" skips every guard
+func skipReason(annotations map[string]string, guard string, annotated string) (string, bool) {
+	skip, ok := annotations[skipAnnotation]
+	if !ok {
+		return "", false
+	}
+	for _, name := range strings.Split(skip, ",") {
+		name = strings.TrimSpace(name)
+		if name != guard && name != "all" {
+			continue
+		}
+		reason := strings.TrimSpace(annotations[skipReasonAnnotation])
+		if reason == "" {
+			log.Warnf("%s skips guard '%s' without '%s', the skip is ignored", annotated, guard, skipReasonAnnotation)
+			return "", false
+		}
+		return reason, true
+	}
+	return "", false
+}
+
+// suppressFindings splits the findings of the guard into the reported ones and the ones suppressed by the skip annotations
+// of the Argo CD Application or of the resource of the finding
+func suppressFindings(app *GuardContext, guard string, findings []Finding) ([]Finding, []Finding) {
+	reported := make([]Finding, 0, len(findings))
+	suppressed := make([]Finding, 0)
+	for _, finding := range findings {
+		if reason, ok := app.applicationSkips(guard); ok {
+			finding.Suppression = &Suppression{Reason: reason}
+			suppressed = append(suppressed, finding)
+			continue
+		}
+		if resource := findingResource(app.Resources, finding); resource != nil {
+			if reason, ok := skipReason(resourceAnnotations(resource), guard, finding.Resource()); ok {
+				finding.Suppression = &Suppression{Resource: finding.Resource(), Reason: reason}
+				suppressed = append(suppressed, finding)
+				continue
+			}
+		}
+		reported = append(reported, finding)
+	}
+	return reported, suppressed
+}
+
+// applicationSkips returns the reason if the annotations of the Argo CD Application skip the guard
+func (app *GuardContext) applicationSkips(guard string) (string, bool) {
+	if app.Application == nil {
+		return "", false
+	}
+	return skipReason(app.Application.Annotations, guard, "Application "+app.Application.Name)
+}
+
+// skips tells whether the Argo CD Application or the resource skips the guard, a nil resource checks the Application only.
+// Guards changing the live objects check it before patching, the findings are only suppressed once every guard has run.
+func (app *GuardContext) skips(guard string, resource *argoappv1.ResourceDiff) bool {
+	if _, ok := app.applicationSkips(guard); ok {
+		return true
+	}
+	if resource == nil {
+		return false
+	}
+	_, ok := skipReason(resourceAnnotations(resource), guard, resource.Kind+":"+resource.Name)
+	return ok
+}
+
+// findingResource returns the resource of the application the finding is about, or nil
+func findingResource(resourceDiffs []*argoappv1.ResourceDiff, finding Finding) *argoappv1.ResourceDiff {
+	if finding.Kind == "" || finding.Name == "" {
+		return nil
+	}
+	key := resourceKey(finding.Group, finding.Kind, finding.Name)
+	for _, resource := range resourceDiffs {
+		if resourceKey(resource.Group, resource.Kind, resource.Name) != key {
+			continue
+		}
+		//Manifests without namespace are deployed to the destination namespace
+		if resource.Namespace == finding.Namespace || resource.Namespace == "" || finding.Namespace == "" {
+			return resource
+		}
+	}
+	return nil
+}
+
+// resourceAnnotations returns the annotations of the target object, or of the live object if it is going to be pruned
+func resourceAnnotations(resource *argoappv1.ResourceDiff) map[string]string {
+	if target, err := resource.TargetObject(); err == nil && target != nil {
+		return target.GetAnnotations()
+	}
+	if live, err := resource.LiveObject(); err == nil && live != nil {
+		return live.GetAnnotations()
+	}
+	return nil
+}
+
+// logSuppressed writes the suppressed findings to the log, so the bypasses are visible without a report
+func logSuppressed(findings []Finding) {
+	for _, finding := range findings {
+		log.Infof("Suppressed (%s): %s", finding.Suppression.Reason, finding.String())
+	}
+}
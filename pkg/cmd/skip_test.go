@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+func skippedDeployment(annotations string) string {
+	return strings.Replace(pdbDeployment, "  namespace: web-prd\n", "  namespace: web-prd\n  annotations:\n"+annotations, 1)
+}
+
+func TestSkipResource(t *testing.T) {
+	app := &GuardContext{
+		AppName: "web-prd",
+		Resources: manifestsToResourceDiffs(t, skippedDeployment(`
+    cd-guard.keikoproj.io/skip: "hpa, pdb"
+    cd-guard.keikoproj.io/skip-reason: "JIRA-123 single replica batch job"
+`)),
+		Config:     &GuardConfig{},
+		Production: true,
+	}
+	report := evaluateGuards(app, []Guard{LookupGuard("pdb")})
+	assert.Empty(t, report.Findings)
+	assert.Equal(t, []string{"pdb-missing"}, findingRules(report.Suppressed))
+	assert.Equal(t, &Suppression{Resource: "Deployment.apps:web-prd/web", Reason: "JIRA-123 single replica batch job"}, report.Suppressed[0].Suppression)
+	assert.Equal(t, exitCodeOK, report.exitCode())
+
+	app.Resources = manifestsToResourceDiffs(t, skippedDeployment(`
+    cd-guard.keikoproj.io/skip: pdb
+`))
+	report = evaluateGuards(app, []Guard{LookupGuard("pdb")})
+	assert.Equal(t, []string{"pdb-missing"}, findingRules(report.Findings))
+	assert.Empty(t, report.Suppressed)
+}
+
+func TestSkipApplication(t *testing.T) {
+	application := &argoappv1.Application{}
+	application.Name = "web-prd"
+	application.Annotations = map[string]string{skipAnnotation: "all", skipReasonAnnotation: "migration"}
+	app := &GuardContext{
+		AppName:     "web-prd",
+		Application: application,
+		Resources:   manifestsToResourceDiffs(t, pdbDeployment),
+		Config:      &GuardConfig{},
+		Production:  true,
+	}
+	report := evaluateGuards(app, []Guard{LookupGuard("pdb")})
+	assert.Empty(t, report.Findings)
+	assert.Equal(t, &Suppression{Reason: "migration"}, report.Suppressed[0].Suppression)
+
+	combined := newCombinedReport()
+	combined.addApp(report)
+	assert.Equal(t, "web-prd", combined.Suppressed[0].App)
+	assert.Equal(t, appStatusPassed, combined.Apps[0].Status)
+
+	var b bytes.Buffer
+	assert.Nil(t, writeReport(&b, outputJUnit, report))
+	suites := junitTestSuites{}
+	assert.Nil(t, xml.Unmarshal(b.Bytes(), &suites))
+	assert.EqualValues(t, 1, suites.Suites[0].Skipped)
+	assert.NotNil(t, suites.Suites[0].TestCases[0].Skipped)
+
+	b.Reset()
+	assert.Nil(t, writeReport(&b, outputSARIF, report))
+	assert.Contains(t, b.String(), `"justification": "migration"`)
+}
+
+func TestHpaPatchHonoursSkipsAndConfig(t *testing.T) {
+	live := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web-prd
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web","namespace":"web-prd"},"spec":{"replicas":2}}'
+spec:
+  replicas: 5
+`
+	hpa := strings.Replace(hpaManifest("apps/v1", "Deployment", "web"), "web-qal", "web-prd", 1)
+	config := &GuardConfig{}
+	guardHpa := func(deployment string, application *argoappv1.Application) *fakeAppClient {
+		appIf := &fakeAppClient{}
+		app := changeContext(t, []string{strings.Replace(deployment, "  replicas: 2\n", "", 1), hpa}, []string{live})
+		app.Application = application
+		app.Config = config
+		app.Patcher = &argoCDPatcher{appIf: appIf, appName: "web-prd"}
+		app.DryRun = false
+		_, err := LookupGuard("hpa").Evaluate(app)
+		assert.Nil(t, err)
+		return appIf
+	}
+
+	assert.EqualValues(t, 1, len(guardHpa(pdbDeployment, nil).patches))
+
+	//A skipped error doesn't hold back the patch of the others
+	hpa += `---
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: worker
+  namespace: web-prd
+  annotations:
+    cd-guard.keikoproj.io/skip: hpa
+    cd-guard.keikoproj.io/skip-reason: "the worker is deployed by another application"
+spec:
+  maxReplicas: 10
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: worker
+`
+	assert.EqualValues(t, 1, len(guardHpa(pdbDeployment, nil).patches))
+	assert.Empty(t, guardHpa(skippedDeployment(`
+    cd-guard.keikoproj.io/skip: hpa
+    cd-guard.keikoproj.io/skip-reason: "JIRA-123 replicas managed by a cron job"
+`), nil).patches)
+
+	application := &argoappv1.Application{}
+	application.Name = "web-prd"
+	application.Annotations = map[string]string{skipAnnotation: "hpa", skipReasonAnnotation: "migration"}
+	assert.Empty(t, guardHpa(pdbDeployment, application).patches)
+
+	//The error blocks the patches unless the guard config downgrades, disables or excepts it
+	hpa = strings.Replace(hpa, "    cd-guard.keikoproj.io/skip: hpa\n", "", 1)
+	assert.Empty(t, guardHpa(pdbDeployment, nil).patches)
+	config = &GuardConfig{Guards: map[string]GuardSettings{"hpa": {Rules: map[string]RuleSettings{"hpa-target-missing": {Severity: SeverityWarning}}}}}
+	assert.EqualValues(t, 1, len(guardHpa(pdbDeployment, nil).patches))
+	config = &GuardConfig{Exceptions: []GuardException{{Rule: "hpa-target-missing", Name: "worker", Reason: "deployed by another application"}}}
+	assert.EqualValues(t, 1, len(guardHpa(pdbDeployment, nil).patches))
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	argocdclient "github.com/argoproj/argo-cd/pkg/apiclient"
+	"github.com/argoproj/argo-cd/pkg/apiclient/application"
+	projectpkg "github.com/argoproj/argo-cd/pkg/apiclient/project"
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/argo-cd/util"
+	"github.com/spf13/cobra"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// syncOptions are the flags of the sync command
+type syncOptions struct {
+	guardRunOptions
+
+	revision string
+	prune    bool
+}
+
+// NewSyncCommand returns a command which runs all guards, syncs the application only if they pass,
+// and waits for the sync to finish and the application to be healthy
+func NewSyncCommand(o *GuardOptions) *cobra.Command {
+	var opts syncOptions
+	var command = &cobra.Command{
+		Use:   "sync <App Name>",
+		Short: "Execute all guards, then sync the application and wait until it is healthy",
+	}
+
+	command.Run = func(c *cobra.Command, args []string) {
+		if err := validateOutput(opts.output); err != nil {
+			log.Error(err)
+			os.Exit(exitCodeGuardError)
+		}
+		if err := validateFailOn(opts.failOn); err != nil {
+			log.Error(err)
+			os.Exit(exitCodeGuardError)
+		}
+		config, err := loadGuardConfig(opts.guardConfig)
+		if err != nil {
+			log.Error(err)
+			os.Exit(exitCodeGuardError)
+		}
+		opts.config = config
+
+		appName := appNameFromArgs(args)
+		if appName == "" {
+			c.HelpFunc()(c, args)
+			os.Exit(exitCodeGuardError)
+		}
+
+		os.Exit(runSync(o.clientOpts, appName, enabledGuards(config), opts))
+	}
+	command.Flags().BoolVar(&opts.dryRun, "dryRun", false, "If true, guards won't make any changes and the sync is a dry run")
+	command.Flags().UintVar(&opts.timeout, "timeout", defaultCheckTimeoutSeconds, "Time out after this many seconds guarding, syncing and waiting for the application, 0 waits forever")
+	command.Flags().BoolVar(&opts.hardRefresh, "hard-refresh", false, "Regenerate the manifests of the application instead of using the Argo CD cache before guarding it")
+	command.Flags().BoolVar(&opts.production, "production", false, "Guard the application as a production application, by default it is decided by the application name suffix '-prd', '-prod' or '-production'")
+	command.Flags().StringVar(&opts.journal, "journal", defaultJournalPath(), "Record the patches of the guards in this journal, revert them with the undo command")
+	command.Flags().StringVar(&opts.failOn, "fail-on", failOnAll, "Which error findings stop the sync. One of: all|new, new ignores the findings the live state already has")
+	command.Flags().StringVarP(&opts.output, "output", "o", outputText, "Output format of the findings. One of: text|json|junit|sarif")
+	command.Flags().StringVar(&opts.reportFile, "report-file", "", "Write the json|junit|sarif report to this file instead of stdout")
+	command.Flags().StringVar(&opts.guardConfig, "guard-config", "", "Path of the guard config, "+defaultGuardConfigPath+" is used if it exists")
+	command.Flags().StringVar(&opts.revision, "revision", "", "Sync to a specific revision, the target revision of the application by default")
+	command.Flags().BoolVar(&opts.prune, "prune", false, "Allow deleting unexpected resources")
+	command.Flags().ParseErrorsWhitelist.UnknownFlags = true
+	command.PersistentFlags().ParseErrorsWhitelist.UnknownFlags = true
+	command.FParseErrWhitelist.UnknownFlags = true
+
+	return command
+}
+
+// runSync guards and syncs the named application and returns the exit status of the command
+func runSync(clientOpts *argocdclient.ClientOptions, appName string, guardsToRun []Guard, opts syncOptions) int {
+	conn, appIf := newApplicationClient(clientOpts)
+	defer util.Close(conn)
+	projConn, projIf := newProjectClient(clientOpts)
+	defer util.Close(projConn)
+	ctx, cancel := withTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	return syncApp(ctx, appIf, projIf, appName, guardsToRun, opts)
+}
+
+// syncApp guards the application, syncs it if the guards passed and waits for the result.
+// It returns the exit status of the command, the guard exit status if the application is not synced.
+func syncApp(ctx context.Context, appIf application.ApplicationServiceClient, projIf projectpkg.ProjectServiceClient, appName string, guardsToRun []Guard, opts syncOptions) int {
+	report, err := guardApp(ctx, appIf, projIf, appName, guardsToRun, opts.guardRunOptions)
+	if err != nil {
+		log.Errorf("Not able to guard application %s: %v", appName, err)
+		if ctx.Err() == context.DeadlineExceeded {
+			return exitCodeTimeout
+		}
+		return exitCodeGuardError
+	}
+	if code := finishReport(report, opts.guardRunOptions); code != exitCodeOK {
+		log.Errorf("Guards didn't pass, application %s is not synced", appName)
+		return code
+	}
+
+	log.Infof("Guards passed, syncing application %s", appName)
+	_, err = appIf.Sync(ctx, &application.ApplicationSyncRequest{
+		Name:     &appName,
+		Revision: opts.revision,
+		DryRun:   opts.dryRun,
+		Prune:    opts.prune,
+	})
+	if err != nil {
+		log.Errorf("Not able to sync application %s: %v", appName, err)
+		if ctx.Err() == context.DeadlineExceeded {
+			return exitCodeTimeout
+		}
+		return exitCodeSyncFailed
+	}
+	return waitForSync(ctx, appIf, appName, !opts.dryRun)
+}
+
+// waitForSync waits until the sync operation finished and, if waitHealth is set, the application is healthy.
+// It gives up once the context is done.
+func waitForSync(ctx context.Context, appIf application.ApplicationServiceClient, appName string, waitHealth bool) int {
+	for {
+		app, err := appIf.Get(ctx, &application.ApplicationQuery{Name: &appName})
+		if err != nil {
+			log.Errorf("Not able to get application %s: %v", appName, err)
+			if ctx.Err() == context.DeadlineExceeded {
+				return exitCodeTimeout
+			}
+			return exitCodeSyncFailed
+		}
+		if code, done := syncResult(app, waitHealth); done {
+			return code
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Errorf("Timed out waiting for application %s, health is %s", appName, app.Status.Health.Status)
+			return exitCodeTimeout
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// syncResult tells whether the sync of the application is finished, and its exit status
+func syncResult(app *argoappv1.Application, waitHealth bool) (int, bool) {
+	state := app.Status.OperationState
+	if app.Operation != nil || state == nil || !state.Phase.Completed() {
+		return exitCodeOK, false
+	}
+	if !state.Phase.Successful() {
+		log.Errorf("Sync of application %s %s: %s", app.Name, state.Phase, state.Message)
+		return exitCodeSyncFailed, true
+	}
+	if !waitHealth {
+		log.Infof("Sync of application %s succeeded", app.Name)
+		return exitCodeOK, true
+	}
+
+	health := app.Status.Health
+	switch health.Status {
+	case argoappv1.HealthStatusHealthy, argoappv1.HealthStatusSuspended:
+		log.Infof("Application %s is synced and %s", app.Name, health.Status)
+		return exitCodeOK, true
+	case argoappv1.HealthStatusDegraded, argoappv1.HealthStatusMissing, argoappv1.HealthStatusUnknown:
+		//A synced application doesn't recover from Missing or Unknown by itself, don't wait for it forever
+		log.Errorf("Application %s is synced but %s: %s", app.Name, health.Status, health.Message)
+		return exitCodeUnhealthy, true
+	}
+	return exitCodeOK, false
+}
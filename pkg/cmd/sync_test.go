@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/argoproj/argo-cd/common"
+	"github.com/argoproj/argo-cd/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+// fakeAppClient serves an application from memory, Get returns the given states in order and repeats the last one
+type fakeAppClient struct {
+	application.ApplicationServiceClient
+
+	states    []*argoappv1.Application
+	resources []*argoappv1.ResourceDiff
+	getErr    error
+	synced    bool
+	refreshes []string
+	patches   []*application.ApplicationResourcePatchRequest
+}
+
+func (c *fakeAppClient) Get(ctx context.Context, in *application.ApplicationQuery, opts ...grpc.CallOption) (*argoappv1.Application, error) {
+	if c.getErr != nil {
+		return nil, c.getErr
+	}
+	if in.Refresh != nil {
+		c.refreshes = append(c.refreshes, *in.Refresh)
+	}
+	app := c.states[0]
+	if len(c.states) > 1 {
+		c.states = c.states[1:]
+	}
+	return app, nil
+}
+
+func (c *fakeAppClient) ManagedResources(ctx context.Context, in *application.ResourcesQuery, opts ...grpc.CallOption) (*application.ManagedResourcesResponse, error) {
+	return &application.ManagedResourcesResponse{Items: c.resources}, nil
+}
+
+func (c *fakeAppClient) Sync(ctx context.Context, in *application.ApplicationSyncRequest, opts ...grpc.CallOption) (*argoappv1.Application, error) {
+	c.synced = true
+	return c.states[0], nil
+}
+
+func (c *fakeAppClient) PatchResource(ctx context.Context, in *application.ApplicationResourcePatchRequest, opts ...grpc.CallOption) (*application.ApplicationResourceResponse, error) {
+	c.patches = append(c.patches, in)
+	return &application.ApplicationResourceResponse{}, nil
+}
+
+func appState(operation bool, phase argoappv1.OperationPhase, health argoappv1.HealthStatusCode) *argoappv1.Application {
+	app := &argoappv1.Application{}
+	app.Name = "web-qal"
+	if operation {
+		app.Operation = &argoappv1.Operation{Sync: &argoappv1.SyncOperation{}}
+	}
+	if phase != "" {
+		app.Status.OperationState = &argoappv1.OperationState{Phase: phase}
+	}
+	app.Status.Health.Status = health
+	return app
+}
+
+func testSyncOptions() syncOptions {
+	return syncOptions{guardRunOptions: guardRunOptions{dryRun: true, config: &GuardConfig{}}}
+}
+
+func TestSyncHealthy(t *testing.T) {
+	pollInterval = time.Millisecond
+	appIf := &fakeAppClient{states: []*argoappv1.Application{
+		appState(false, argoappv1.OperationSucceeded, argoappv1.HealthStatusHealthy), // before the refresh
+		appState(false, argoappv1.OperationSucceeded, argoappv1.HealthStatusHealthy), // refresh before guarding
+		appState(true, argoappv1.OperationSucceeded, argoappv1.HealthStatusHealthy),  // the previous operation
+		appState(false, argoappv1.OperationRunning, argoappv1.HealthStatusProgressing),
+		appState(false, argoappv1.OperationSucceeded, argoappv1.HealthStatusProgressing),
+		appState(false, argoappv1.OperationSucceeded, argoappv1.HealthStatusHealthy),
+	}, resources: manifestsToResourceDiffs(t, pdbDeployment)}
+	opts := testSyncOptions()
+	opts.dryRun = false
+
+	assert.Equal(t, exitCodeOK, syncApp(context.Background(), appIf, nil, "web-qal", Guards(), opts))
+	assert.True(t, appIf.synced)
+}
+
+func TestSyncNotRunWhenGuardsFail(t *testing.T) {
+	appIf := &fakeAppClient{
+		states:    []*argoappv1.Application{appState(false, "", argoappv1.HealthStatusHealthy)},
+		resources: manifestsToResourceDiffs(t, pdbDeployment),
+	}
+	assert.Equal(t, exitCodeViolations, syncApp(context.Background(), appIf, nil, "web-prd", Guards(), testSyncOptions()))
+	assert.False(t, appIf.synced)
+
+	appIf = &fakeAppClient{getErr: fmt.Errorf("permission denied")}
+	assert.Equal(t, exitCodeGuardError, syncApp(context.Background(), appIf, nil, "web-qal", Guards(), testSyncOptions()))
+	assert.False(t, appIf.synced)
+}
+
+func TestSyncResult(t *testing.T) {
+	code, done := syncResult(appState(false, argoappv1.OperationFailed, argoappv1.HealthStatusHealthy), true)
+	assert.True(t, done)
+	assert.Equal(t, exitCodeSyncFailed, code)
+
+	code, done = syncResult(appState(false, argoappv1.OperationSucceeded, argoappv1.HealthStatusDegraded), true)
+	assert.True(t, done)
+	assert.Equal(t, exitCodeUnhealthy, code)
+
+	code, done = syncResult(appState(false, argoappv1.OperationSucceeded, argoappv1.HealthStatusDegraded), false)
+	assert.True(t, done)
+	assert.Equal(t, exitCodeOK, code)
+
+	for _, health := range []string{argoappv1.HealthStatusMissing, argoappv1.HealthStatusUnknown} {
+		code, done = syncResult(appState(false, argoappv1.OperationSucceeded, health), true)
+		assert.True(t, done)
+		assert.Equal(t, exitCodeUnhealthy, code)
+	}
+
+	_, done = syncResult(appState(false, argoappv1.OperationSucceeded, argoappv1.HealthStatusProgressing), true)
+	assert.False(t, done)
+}
+
+func TestSyncTimeout(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	appIf := &fakeAppClient{states: []*argoappv1.Application{appState(true, argoappv1.OperationRunning, argoappv1.HealthStatusProgressing)}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, exitCodeTimeout, waitForSync(ctx, appIf, "web-qal", true))
+}
+
+func reconciledApp(reconciledAt time.Time, refreshRequested bool) *argoappv1.Application {
+	app := appState(false, "", argoappv1.HealthStatusHealthy)
+	app.Status.ReconciledAt = metav1.NewTime(reconciledAt)
+	if refreshRequested {
+		app.Annotations = map[string]string{common.AnnotationKeyRefresh: string(argoappv1.RefreshTypeHard)}
+	}
+	return app
+}
+
+func TestRefreshApp(t *testing.T) {
+	pollInterval = time.Millisecond
+	before := time.Now().Add(-time.Minute)
+	after := time.Now()
+	appIf := &fakeAppClient{states: []*argoappv1.Application{
+		reconciledApp(before, false),
+		reconciledApp(before, true),
+		reconciledApp(before, false),
+		reconciledApp(after, false),
+		reconciledApp(before, false),
+	}}
+	app, err := refreshApp(context.Background(), appIf, "web-qal", true)
+	assert.Nil(t, err)
+	assert.Equal(t, after.Unix(), app.Status.ReconciledAt.Unix())
+	assert.Equal(t, []string{string(argoappv1.RefreshTypeHard)}, appIf.refreshes)
+}
+
+func TestRefreshAppTimeout(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	before := time.Now().Add(-time.Minute)
+	appIf := &fakeAppClient{states: []*argoappv1.Application{reconciledApp(before, false), reconciledApp(before, true)}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := refreshApp(ctx, appIf, "web-qal", false)
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{string(argoappv1.RefreshTypeNormal)}, appIf.refreshes)
+}
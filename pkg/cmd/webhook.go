@@ -0,0 +1,337 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// webhookOptions are the flags of the webhook command
+type webhookOptions struct {
+	address     string
+	tlsCertFile string
+	tlsKeyFile  string
+	guards      []string
+	guardConfig string
+}
+
+// NewWebhookCommand returns a command which serves the cross-object guards as a validating admission webhook,
+// so changes applied outside Argo CD are guarded too
+func NewWebhookCommand(o *GuardOptions) *cobra.Command {
+	var opts webhookOptions
+	var command = &cobra.Command{
+		Use:   "webhook",
+		Short: "Serve the guards as validating admission webhook over HTTPS",
+	}
+
+	command.Run = func(c *cobra.Command, args []string) {
+		webhook, err := newAdmissionWebhook(o, opts)
+		if err != nil {
+			log.Error(err)
+			os.Exit(exitCodeGuardError)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/validate", webhook)
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		log.Infof("Serving admission webhook on %s", opts.address)
+		server := &http.Server{Addr: opts.address, Handler: mux}
+		log.Fatal(server.ListenAndServeTLS(opts.tlsCertFile, opts.tlsKeyFile))
+	}
+	command.Flags().StringVar(&opts.address, "address", ":8443", "The address to serve the webhook on")
+	command.Flags().StringVar(&opts.tlsCertFile, "tls-cert-file", "", "File containing the x509 certificate for HTTPS")
+	command.Flags().StringVar(&opts.tlsKeyFile, "tls-private-key-file", "", "File containing the x509 private key matching --tls-cert-file")
+	command.Flags().StringSliceVar(&opts.guards, "guards", []string{"hpa", "ingress"}, "The guards to run on every admission request")
+	command.Flags().StringVar(&opts.guardConfig, "guard-config", "", "Path of the guard config, "+defaultGuardConfigPath+" is used if it exists")
+	o.configFlags.AddFlags(command.Flags())
+
+	return command
+}
+
+// admissionWebhook guards an object together with its sibling objects in the same namespace
+type admissionWebhook struct {
+	client dynamic.Interface
+	guards []Guard
+	config *GuardConfig
+}
+
+func newAdmissionWebhook(o *GuardOptions, opts webhookOptions) (*admissionWebhook, error) {
+	config, err := loadGuardConfig(opts.guardConfig)
+	if err != nil {
+		return nil, err
+	}
+	guardsToRun := make([]Guard, 0)
+	for _, name := range opts.guards {
+		guard := LookupGuard(name)
+		if guard == nil {
+			return nil, fmt.Errorf("unknown guard '%s'", name)
+		}
+		guardsToRun = append(guardsToRun, guard)
+	}
+	kube, err := o.newKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	return &admissionWebhook{client: kube.client, guards: guardsToRun, config: config}, nil
+}
+
+// siblingResource is a kind of object looked up from the cluster, the first served version is used
+type siblingResource struct {
+	group    string
+	kind     string
+	versions []schema.GroupVersionResource
+}
+
+var siblingResources = []siblingResource{
+	{"autoscaling", "HorizontalPodAutoscaler", []schema.GroupVersionResource{
+		{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"},
+		{Group: "autoscaling", Version: "v2beta2", Resource: "horizontalpodautoscalers"},
+		{Group: "autoscaling", Version: "v1", Resource: "horizontalpodautoscalers"},
+	}},
+	{"networking.k8s.io", "Ingress", []schema.GroupVersionResource{
+		{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"},
+		{Group: "extensions", Version: "v1beta1", Resource: "ingresses"},
+	}},
+	{"", "Service", []schema.GroupVersionResource{{Version: "v1", Resource: "services"}}},
+	{"apps", "Deployment", []schema.GroupVersionResource{{Group: "apps", Version: "v1", Resource: "deployments"}}},
+	{"apps", "StatefulSet", []schema.GroupVersionResource{{Group: "apps", Version: "v1", Resource: "statefulsets"}}},
+	{"argoproj.io", "Rollout", []schema.GroupVersionResource{{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}}},
+}
+
+// guarded tells whether the object is one of the sibling kinds, other objects are always allowed
+func guarded(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	for _, resource := range siblingResources {
+		if resource.kind != gvk.Kind {
+			continue
+		}
+		if resource.group == gvk.Group || (gvk.Group == "extensions" && (gvk.Kind == "Ingress" || gvk.Kind == "Deployment")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *admissionWebhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review := admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(rw, fmt.Sprintf("not an AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = w.review(review.Request)
+	review.Request = nil
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(review); err != nil {
+		log.Errorf("Not able to write the admission response: %v", err)
+	}
+}
+
+// review denies the request if it introduces error findings, problems which already exist in the namespace don't block it.
+// The request is allowed if the guards are not able to evaluate it, the webhook should never block a namespace by itself.
+func (w *admissionWebhook) review(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	response := &admissionv1beta1.AdmissionResponse{UID: request.UID, Allowed: true}
+	if request.Operation == admissionv1beta1.Delete || len(request.Object.Raw) == 0 {
+		return response
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(request.Object.Raw); err != nil {
+		log.Errorf("Not able to decode %s %s/%s: %v", request.Kind.Kind, request.Namespace, request.Name, err)
+		return response
+	}
+	if !guarded(obj) {
+		return response
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(request.Namespace)
+	}
+
+	findings, err := w.newFindings(obj)
+	if err != nil {
+		log.Errorf("Not able to guard %s %s/%s, allowed: %v", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+		return response
+	}
+	logFindings(findings)
+
+	messages := make([]string, 0)
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			messages = append(messages, finding.String())
+		}
+	}
+	if len(messages) > 0 {
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonForbidden,
+			Code:    http.StatusForbidden,
+			Message: strings.Join(messages, "\n"),
+		}
+	}
+	return response
+}
+
+// newFindings guards the namespace with and without the object, and returns the findings which only exist with it
+func (w *admissionWebhook) newFindings(obj *unstructured.Unstructured) ([]Finding, error) {
+	siblings, err := w.siblings(obj.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	desired := make([]*unstructured.Unstructured, 0, len(siblings)+1)
+	for _, sibling := range siblings {
+		desired = append(desired, desiredState(sibling))
+	}
+
+	before, err := w.evaluate(obj.GetNamespace(), desired, siblings)
+	if err != nil {
+		return nil, err
+	}
+
+	key := manifestKey(obj, obj.GetNamespace())
+	targets := make([]*unstructured.Unstructured, 0, len(desired)+1)
+	for _, target := range desired {
+		if manifestKey(target, target.GetNamespace()) != key {
+			targets = append(targets, target)
+		}
+	}
+	//The API server has defaulted and merged the admitted object, e.g. 'spec.replicas', judge what was applied instead
+	targets = append(targets, desiredState(obj))
+	after, err := w.evaluate(obj.GetNamespace(), targets, siblings)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := make([]Finding, 0)
+	for i, existing := range matchFindings(after, before) {
+		if !existing {
+			findings = append(findings, after[i])
+		}
+	}
+	return findings, nil
+}
+
+func (w *admissionWebhook) evaluate(namespace string, targets []*unstructured.Unstructured, lives []*unstructured.Unstructured) ([]Finding, error) {
+	resourceDiffs, err := manifestResourceDiffs(targets, lives)
+	if err != nil {
+		return nil, err
+	}
+	app := &GuardContext{
+		Ctx:       context.Background(),
+		AppName:   namespace,
+		Resources: resourceDiffs,
+		DryRun:    true,
+		Config:    w.config,
+	}
+	return evaluateGuards(app, w.guards).Findings, nil
+}
+
+// siblings lists the objects in the namespace the guards look at
+func (w *admissionWebhook) siblings(namespace string) ([]*unstructured.Unstructured, error) {
+	result := make([]*unstructured.Unstructured, 0)
+	for _, resource := range siblingResources {
+		for _, gvr := range resource.versions {
+			list, err := w.client.Resource(gvr).Namespace(namespace).List(metav1.ListOptions{})
+			if apierrors.IsNotFound(err) { //The version is not served by the cluster
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("not able to list %s: %v", gvr.String(), err)
+			}
+			for i := range list.Items {
+				result = append(result, &list.Items[i])
+			}
+			break
+		}
+	}
+	return result, nil
+}
+
+// desiredState returns the last applied configuration of a live object, or the live object if it was not applied by kubectl
+func desiredState(live *unstructured.Unstructured) *unstructured.Unstructured {
+	if lastApplied, ok := live.GetAnnotations()["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON([]byte(lastApplied)); err == nil {
+			if obj.GetNamespace() == "" {
+				obj.SetNamespace(live.GetNamespace())
+			}
+			return obj
+		}
+	}
+	obj := live.DeepCopy()
+	delete(obj.Object, "status")
+	return obj
+}
+
+// findingKey identifies the rule and resource of a finding regardless of its message
+func findingKey(finding Finding) string {
+	return strings.Join([]string{finding.Guard, finding.Rule, finding.Resource()}, "/")
+}
+
+// matchFindings pairs every finding with at most one of the others and tells which findings have a pair.
+// Findings of the same key and message are paired first, the rest by key, so a second finding of a rule
+// on a resource, e.g. of another container, is never taken for the first one.
+func matchFindings(findings []Finding, others []Finding) []bool {
+	matched := make([]bool, len(findings))
+	byMessage := make(map[string]int)
+	byKey := make(map[string]int)
+	for _, other := range others {
+		byMessage[findingKey(other)+"\n"+other.Message]++
+		byKey[findingKey(other)]++
+	}
+	for i, finding := range findings {
+		if key := findingKey(finding) + "\n" + finding.Message; byMessage[key] > 0 {
+			byMessage[key]--
+			byKey[findingKey(finding)]--
+			matched[i] = true
+		}
+	}
+	//The message may change with the state, e.g. the number of replicas
+	for i, finding := range findings {
+		if key := findingKey(finding); !matched[i] && byKey[key] > 0 {
+			byKey[key]--
+			matched[i] = true
+		}
+	}
+	return matched
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+const webhookDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web-qal
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web","namespace":"web-qal"},"spec":{"template":{"metadata":{"labels":{"app":"web"}}}}}'
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels:
+        app: web
+`
+
+// webhookHpa is autoscaling/v2, the version the webhook lists first. The fake client only serves the version an object is stored in.
+const webhookHpa = `
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  maxReplicas: 10
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web
+`
+
+func toUnstructured(t *testing.T, manifest string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	assert.Nil(t, yaml.NewYAMLOrJSONDecoder(strings.NewReader(manifest), 4096).Decode(&obj.Object))
+	return obj
+}
+
+func newTestWebhook(t *testing.T, manifests ...string) *admissionWebhook {
+	objects := make([]runtime.Object, 0)
+	for _, manifest := range manifests {
+		objects = append(objects, toUnstructured(t, manifest))
+	}
+	return &admissionWebhook{
+		client: fake.NewSimpleDynamicClient(runtime.NewScheme(), objects...),
+		guards: []Guard{LookupGuard("hpa"), LookupGuard("ingress")},
+		config: &GuardConfig{},
+	}
+}
+
+func admissionRequest(t *testing.T, manifest string) *admissionv1beta1.AdmissionRequest {
+	obj := toUnstructured(t, manifest)
+	raw, err := obj.MarshalJSON()
+	assert.Nil(t, err)
+	return &admissionv1beta1.AdmissionRequest{
+		UID:       "1",
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Operation: admissionv1beta1.Update,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestWebhookDeniesReplicasOfHpaTarget(t *testing.T) {
+	webhook := newTestWebhook(t, webhookDeployment, webhookHpa)
+
+	//The API server sends the merged object, 'spec.replicas' is always set
+	response := webhook.review(admissionRequest(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web-qal
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web","namespace":"web-qal"},"spec":{"replicas":3,"template":{"metadata":{"labels":{"app":"web"}}}}}'
+spec:
+  replicas: 3
+  template:
+    metadata:
+      labels:
+        app: web
+`))
+	assert.False(t, response.Allowed)
+	assert.Contains(t, response.Result.Message, "hpa-replicas-set")
+
+	response = webhook.review(admissionRequest(t, webhookDeployment))
+	assert.True(t, response.Allowed)
+
+	response = webhook.review(admissionRequest(t, strings.Replace(webhookDeployment, "  replicas: 2\n", "  replicas: 1\n", 1)))
+	assert.True(t, response.Allowed)
+}
+
+func TestWebhookAllowsExistingViolations(t *testing.T) {
+	deployment := strings.Replace(webhookDeployment, `"spec":{`, `"spec":{"replicas":2,`, 1)
+	webhook := newTestWebhook(t, deployment, webhookHpa)
+	siblings, err := webhook.siblings("web-qal")
+	assert.Nil(t, err)
+	existing, err := webhook.evaluate("web-qal", []*unstructured.Unstructured{desiredState(siblings[0]), desiredState(siblings[1])}, siblings)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hpa-replicas-set"}, findingRules(existing))
+
+	response := webhook.review(admissionRequest(t, `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  selector:
+    app: web
+`))
+	assert.True(t, response.Allowed)
+
+	response = webhook.review(admissionRequest(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  namespace: web-qal
+`))
+	assert.True(t, response.Allowed)
+}
+
+func TestWebhookDeniesNewViolationOfExistingRule(t *testing.T) {
+	deployment := probeDeployment(`
+        readinessProbe:
+          tcpSocket:
+            port: https
+`)
+	webhook := newTestWebhook(t, deployment)
+	webhook.guards = []Guard{LookupGuard("probe")}
+
+	response := webhook.review(admissionRequest(t, deployment+`
+      - name: sidecar
+        image: sidecar:latest
+        readinessProbe:
+          tcpSocket:
+            port: metrics
+`))
+	assert.False(t, response.Allowed)
+	assert.Contains(t, response.Result.Message, "container sidecar")
+	assert.NotContains(t, response.Result.Message, "container app")
+
+	response = webhook.review(admissionRequest(t, deployment))
+	assert.True(t, response.Allowed)
+}
+
+func TestWebhookListsHpaMetrics(t *testing.T) {
+	hpa := webhookHpa + `
+  metrics:
+  - type: Resource
+    resource:
+      name: memory
+      target:
+        type: Utilization
+        averageUtilization: 80
+`
+	webhook := newTestWebhook(t, hpa)
+	siblings, err := webhook.siblings("web-qal")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(siblings))
+	assert.Equal(t, []hpaResourceMetric{{resource: "memory", utilization: true}}, hpaResourceMetrics(siblings[0]))
+
+	deployment := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web-qal
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: web:latest
+        resources:
+          requests:
+            memory: 128Mi
+`
+	assert.True(t, webhook.review(admissionRequest(t, deployment)).Allowed)
+
+	response := webhook.review(admissionRequest(t, strings.Replace(deployment, "memory: 128Mi", "cpu: 100m", 1)))
+	assert.False(t, response.Allowed)
+	assert.Contains(t, response.Result.Message, "hpa-metric-request-missing")
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	argoappv1 "github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+)
+
+// productionAppName matches the "${appName}-${envName}" naming of production applications
+var productionAppName = regexp.MustCompile(`-(prd|prod|production)$`)
+
+// isProductionApp tells whether the application is deployed to production by its name
+func isProductionApp(appName string) bool {
+	return productionAppName.MatchString(appName)
+}
+
+// workload is a Deployment or Rollout of the application together with its target object
+type workload struct {
+	resource *argoappv1.ResourceDiff
+	obj      *unstructured.Unstructured
+}
+
+// isWorkload tells whether the resource runs pods from 'spec.template'
+func isWorkload(resource *argoappv1.ResourceDiff) bool {
+	return resource.Kind == "Deployment" || (resource.Kind == "Rollout" && resource.Group == "argoproj.io")
+}
+
+// workloads returns the Deployments and Rollouts which are going to be deployed, pruned objects are skipped
+func workloads(resourceDiffs []*argoappv1.ResourceDiff) []workload {
+	result := make([]workload, 0)
+	for _, resource := range resourceDiffs {
+		if !isWorkload(resource) {
+			continue
+		}
+		obj, err := resource.TargetObject()
+		if err != nil || obj == nil {
+			continue
+		}
+		result = append(result, workload{resource: resource, obj: obj})
+	}
+	return result
+}
+
+// podTemplateWorkloads returns every object of the application running pods from 'spec.template',
+// StatefulSets and DaemonSets included, pruned objects are skipped
+func podTemplateWorkloads(resourceDiffs []*argoappv1.ResourceDiff) []workload {
+	result := make([]workload, 0)
+	for _, resource := range resourceDiffs {
+		if !isWorkload(resource) && !((resource.Kind == "StatefulSet" || resource.Kind == "DaemonSet") && (resource.Group == "apps" || resource.Group == "extensions")) {
+			continue
+		}
+		obj, err := resource.TargetObject()
+		if err != nil || obj == nil {
+			continue
+		}
+		result = append(result, workload{resource: resource, obj: obj})
+	}
+	return result
+}
+
+// targetObjectsOfKind returns the target objects of the given group and kind
+func targetObjectsOfKind(resourceDiffs []*argoappv1.ResourceDiff, group string, kind string) []*unstructured.Unstructured {
+	result := make([]*unstructured.Unstructured, 0)
+	for _, resource := range resourceDiffs {
+		if resource.Group != group || resource.Kind != kind {
+			continue
+		}
+		obj, err := resource.TargetObject()
+		if err != nil || obj == nil {
+			continue
+		}
+		result = append(result, obj)
+	}
+	return result
+}
+
+// podTemplate returns 'spec.template' of a workload
+func podTemplate(obj *unstructured.Unstructured) (*corev1.PodTemplateSpec, error) {
+	template := &corev1.PodTemplateSpec{}
+	templateObj, found, err := unstructured.NestedMap(obj.Object, "spec", "template")
+	if err != nil || !found {
+		return template, err
+	}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(templateObj, template)
+	return template, err
+}
+
+// declaresPort tells whether one of the containers declares the port by number or by name
+func declaresPort(containers []corev1.Container, port string) bool {
+	for _, container := range containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name == port || strconv.Itoa(int(containerPort.ContainerPort)) == port {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// labelSelector converts the label selector at the given path, a missing selector selects nothing
+func labelSelector(obj *unstructured.Unstructured, fields ...string) (labels.Selector, error) {
+	selectorObj, found, err := unstructured.NestedMap(obj.Object, fields...)
+	if err != nil || !found {
+		return labels.Nothing(), err
+	}
+	selector := &metav1.LabelSelector{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(selectorObj, selector); err != nil {
+		return labels.Nothing(), err
+	}
+	return metav1.LabelSelectorAsSelector(selector)
+}
+
+// sameNamespace compares namespaces of two objects of the application, an empty namespace is the destination namespace
+func sameNamespace(a *unstructured.Unstructured, b *unstructured.Unstructured) bool {
+	return a.GetNamespace() == b.GetNamespace() || a.GetNamespace() == "" || b.GetNamespace() == ""
+}
+
+// toMap returns the object as map, or nil if it's not a map
+func toMap(obj interface{}) map[string]interface{} {
+	m, _ := obj.(map[string]interface{})
+	return m
+}
+
+// servicesByName returns the target Services of the application by name
+func servicesByName(resourceDiffs []*argoappv1.ResourceDiff) map[string]*unstructured.Unstructured {
+	services := make(map[string]*unstructured.Unstructured)
+	for _, service := range targetObjectsOfKind(resourceDiffs, "", "Service") {
+		services[service.GetName()] = service
+	}
+	return services
+}
+
+// targetObjectsByName returns the target objects of the given kind in any of the groups by name
+func targetObjectsByName(resourceDiffs []*argoappv1.ResourceDiff, kind string, groups ...string) map[string]*unstructured.Unstructured {
+	objects := make(map[string]*unstructured.Unstructured)
+	for _, group := range groups {
+		for _, obj := range targetObjectsOfKind(resourceDiffs, group, kind) {
+			objects[obj.GetName()] = obj
+		}
+	}
+	return objects
+}
+
+// resourceKey identifies an object of the application by group, kind and name.
+// Deployments and ReplicaSets of the deprecated "extensions" group are the same objects as in "apps".
+func resourceKey(group string, kind string, name string) string {
+	if group == "extensions" && (kind == "Deployment" || kind == "ReplicaSet") {
+		group = "apps"
+	}
+	return strings.Join([]string{group, kind, name}, "/")
+}